@@ -0,0 +1,205 @@
+package rbmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/streadway/amqp"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+关于批量消费
+ConsumeBatch 把消息攒成批次后调用处理函数：攒够 size 条，或者批次中第一条消息等待了 maxWait 时立即处理。
+
+	应用场景: 批量写入数据库、数仓，减少写入次数
+
+处理结果：
+(1) 成功：对批次中最后一条消息 ack(multiple=true)，一次确认整个批次
+(2) 返回 BatchError：部分失败，BatchError 中列出的消息按处理失败处理（重试或 nack），其他消息逐条 ack
+(3) 返回其他错误或 panic：整个批次处理失败，配置了重试策略时逐条重试，否则 nack(multiple=true)，Reject 包装的错误不重新入队
+
+批量消费时串行处理批次，WithConcurrency 不生效，prefetch 会被提升到不小于 size，否则永远攒不够一个批次。
+同一个通道上的消息按顺序投递，批次之前的消息都已经确认，所以 multiple=true 只会确认本批次的消息。
+无法还原消息体的消息不会进入批次，单独 nack。
+处理函数之外的 panic（还原消息体、ErrorHook、onAck 等）会 nack 正在处理的还没有确认的消息，consumer 与逐条处理时一样返回错误。
+*/
+
+const DefaultBatchMaxWait = time.Second
+
+// BatchHandler 批量处理函数
+type BatchHandler func(ctx context.Context, msgs []*Message) error
+
+// BatchError 批次部分失败，Failed 的 key 为失败的消息在批次中的下标，value 为该消息的错误
+type BatchError struct {
+	Failed map[int]error
+}
+
+func (e *BatchError) Error() string {
+	idx := make([]int, 0, len(e.Failed))
+	for i := range e.Failed {
+		idx = append(idx, i)
+	}
+	sort.Ints(idx)
+	parts := make([]string, 0, len(idx))
+	for _, i := range idx {
+		parts = append(parts, fmt.Sprintf("[%d] %v", i, e.Failed[i]))
+	}
+	return fmt.Sprintf("batch: %d message(s) failed: %s", len(idx), strings.Join(parts, "; "))
+}
+
+// ConsumeBatch 批量监听消费，该方法会阻塞调用，建议开启一个单独的 goroutine 调用，已经在监听时返回 ConsumerIsRunning
+// size：批次的最大条数，必须大于 0
+// maxWait：批次中第一条消息的最长等待时间，小于等于 0 时为 DefaultBatchMaxWait
+// handler：批量处理函数
+func (c *BaseConsumer) ConsumeBatch(size int, maxWait time.Duration, handler BatchHandler) error {
+	if size <= 0 {
+		return BatchSizeInvalid
+	}
+	if maxWait <= 0 {
+		maxWait = DefaultBatchMaxWait
+	}
+	prefetch := c.prefetchCount
+	if prefetch < size {
+		prefetch = size
+	}
+	return c.consume(&consumeMode{
+		prefetch: prefetch,
+		dispatcher: func(ctx context.Context, fault *workerFault) (func(d amqp.Delivery), func()) {
+			return c.batchDispatcher(ctx, size, maxWait, handler, fault)
+		},
+	})
+}
+
+// batchDispatcher 在单独的 goroutine 中攒批次并处理，wait 时处理剩下的不满一个批次的消息
+func (c *BaseConsumer) batchDispatcher(ctx context.Context, size int, maxWait time.Duration, handler BatchHandler,
+	fault *workerFault) (dispatch func(d amqp.Delivery), wait func()) {
+	in := make(chan amqp.Delivery)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		var batch []*inflight
+		var current amqp.Delivery // 正在还原的消息
+		defer func() {
+			if pErr := recover(); pErr != nil {
+				var ds []*amqp.Delivery
+				if current.Acknowledger != nil {
+					ds = append(ds, &current)
+				}
+				for _, f := range batch {
+					ds = append(ds, &f.d)
+				}
+				fault.panicked(pErr, ds...)
+			}
+		}()
+		timer := time.NewTimer(maxWait)
+		stopTimer(timer)
+		defer timer.Stop()
+		flush := func() {
+			stopTimer(timer)
+			if len(batch) > 0 {
+				c.handleBatch(ctx, batch, handler)
+				batch = nil
+			}
+		}
+		for {
+			select {
+			case d, ok := <-in:
+				if !ok {
+					flush()
+					return
+				}
+				current = d
+				f, ok := c.receive(d)
+				if !ok {
+					continue
+				}
+				batch = append(batch, f)
+				if len(batch) == 1 {
+					timer.Reset(maxWait)
+				}
+				if len(batch) >= size {
+					flush()
+				}
+			case <-timer.C:
+				// 定时器已经触发，不能再从 timer.C 读取
+				if len(batch) > 0 {
+					c.handleBatch(ctx, batch, handler)
+					batch = nil
+				}
+			}
+		}
+	}()
+	dispatch = func(d amqp.Delivery) {
+		select {
+		case in <- d:
+		case <-fault.done:
+			// 批次 goroutine 已经 panic，consumer 即将返回错误，消息在关闭 channel 时重新入队
+		}
+	}
+	wait = func() {
+		close(in)
+		<-finished
+	}
+	return dispatch, wait
+}
+
+// stopTimer 停止定时器，并清空已经触发但还没有读取的值，之后可以安全地 Reset
+// 只能在读取 timer.C 的 goroutine 中调用
+func stopTimer(t *time.Timer) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+}
+
+// handleBatch 调用批量处理函数，并根据处理结果 ack 或 nack
+func (c *BaseConsumer) handleBatch(ctx context.Context, batch []*inflight, handler BatchHandler) {
+	msgs := make([]*Message, len(batch))
+	for i, f := range batch {
+		msgs[i] = f.msg
+	}
+	last := batch[len(batch)-1]
+	err := c.invoke(func() error { return handler(ctx, msgs) })
+
+	var bErr *BatchError
+	switch {
+	case err == nil:
+		if err = last.d.Ack(true); err != nil {
+			log.Printf("deliver.Ack: %s\n", err)
+			return
+		}
+		for _, f := range batch {
+			c.acked(f)
+		}
+	case errors.As(err, &bErr):
+		for i, f := range batch {
+			if fErr, failed := bErr.Failed[i]; failed {
+				c.fail(f, fErr)
+				continue
+			}
+			if err := f.d.Ack(false); err != nil {
+				log.Printf("deliver.Ack: %s\n", err)
+				continue
+			}
+			c.acked(f)
+		}
+	case c.opts.retry != nil:
+		for _, f := range batch {
+			c.fail(f, err)
+		}
+	default:
+		for _, f := range batch {
+			c.onError(f.msg, err)
+		}
+		var rErr *rejectError
+		if err = last.d.Nack(true, !errors.As(err, &rErr)); err != nil {
+			log.Printf("deliver.Nack: %s\n", err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package rbmq
+
+import (
+	"context"
+	"errors"
+	"github.com/streadway/amqp"
+	"testing"
+	"time"
+)
+
+func TestBatchPanic(t *testing.T) {
+	panics := func() { panic("boom") }
+	tests := []struct {
+		name       string
+		handlerErr error
+		hook       ErrorHook
+		onAck      func(d amqp.Delivery)
+		wantAcked  int
+		wantNacked int
+	}{
+		{
+			name:       "error hook panics before nack",
+			handlerErr: errors.New("failed"),
+			hook:       func(msg *Message, err error) { panics() },
+			wantNacked: 2,
+		},
+		{
+			name:      "onAck panics after ack",
+			onAck:     func(d amqp.Delivery) { panics() },
+			wantAcked: 1, // 一次 ack(multiple=true) 确认整个批次
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ack := &fakeAcknowledger{}
+			tracker := newAckTracker(ack)
+			c := &BaseConsumer{
+				opts:  consumerOptions{errorHook: tt.hook},
+				onAck: tt.onAck,
+			}
+			fault := newWorkerFault()
+			handler := func(ctx context.Context, msgs []*Message) error { return tt.handlerErr }
+			dispatch, wait := c.batchDispatcher(context.Background(), 2, time.Minute, handler, fault)
+
+			for tag := uint64(1); tag <= 2; tag++ {
+				d := amqp.Delivery{DeliveryTag: tag}
+				tracker.track(&d)
+				dispatch(d)
+			}
+			select {
+			case <-fault.done:
+			case <-time.After(time.Second):
+				t.Fatal("batch panic is not reported")
+			}
+			// 批次 goroutine 已经退出，分发不能阻塞
+			dispatch(amqp.Delivery{DeliveryTag: 3})
+			wait()
+
+			if fault.err == nil {
+				t.Error("fault.err is nil")
+			}
+			if len(ack.acked) != tt.wantAcked || len(ack.nacked) != tt.wantNacked {
+				t.Errorf("acked %v, nacked %v, want %d acked, %d nacked", ack.acked, ack.nacked, tt.wantAcked, tt.wantNacked)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package rbmq
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/streadway/amqp"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+/*
+关于 Claim-Check（凭证检查）模式
+rabbitmq 不适合传输很大的消息体，publisher 通过 WithClaimCheck 开启后，大于阈值的消息体先存入 BlobStore，
+消息只携带存储的引用（x-claim-check 头），消息体为空。
+consumer 通过 WithClaimCheckStore 配置同一个 BlobStore，BaseConsumer 在调用处理函数之前根据引用取回消息体，
+可以选择在消息 ack 之后删除存储的内容。
+
+Claim-Check 在压缩、加密、签名之后进行，存储的是实际要发送的内容，consumer 取回后再验签、解密、解压。
+*/
+
+const (
+	claimCheckHeader     = "x-claim-check"      // 存储的引用
+	claimCheckSizeHeader = "x-claim-check-size" // 存储的消息体大小
+
+	DefaultClaimCheckThreshold = 512 * 1024 // 默认阈值，字节
+)
+
+// BlobStore 存储大消息体
+type BlobStore interface {
+	Put(ctx context.Context, data []byte) (ref string, err error)
+	Get(ctx context.Context, ref string) ([]byte, error) // 引用不存在时返回的错误需要包装 BlobNotFound
+	Delete(ctx context.Context, ref string) error
+}
+
+// FileBlobStore 基于本地文件系统的 BlobStore，publisher 与 consumer 需要共享同一个目录（例如挂载的网络存储）
+type FileBlobStore struct {
+	dir string
+}
+
+// NewFileBlobStore 创建 FileBlobStore，目录不存在时自动创建
+func NewFileBlobStore(dir string) (*FileBlobStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileBlobStore{dir: dir}, nil
+}
+
+func (s *FileBlobStore) Put(ctx context.Context, data []byte) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	ref := hex.EncodeToString(buf)
+	// 先写临时文件再重命名，避免 consumer 读到写了一半的文件
+	tmp := filepath.Join(s.dir, ref+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, ref)); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return ref, nil
+}
+
+func (s *FileBlobStore) Get(ctx context.Context, ref string) ([]byte, error) {
+	path, err := s.path(ref)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", BlobNotFound, ref)
+	}
+	return data, err
+}
+
+func (s *FileBlobStore) Delete(ctx context.Context, ref string) error {
+	path, err := s.path(ref)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// path 引用来自消息头，只允许十六进制字符，防止访问存储目录之外的文件
+func (s *FileBlobStore) path(ref string) (string, error) {
+	if _, err := hex.DecodeString(ref); err != nil || ref == "" {
+		return "", fmt.Errorf("%w: invalid ref %q", BlobNotFound, ref)
+	}
+	return filepath.Join(s.dir, ref), nil
+}
+
+type claimCheckOptions struct {
+	store          BlobStore
+	threshold      int
+	deleteAfterAck bool
+}
+
+// WithClaimCheck 给 publisher 开启 Claim-Check
+// store：存储
+// threshold：阈值，消息体大于该值时存入 store，小于等于 0 时使用 DefaultClaimCheckThreshold
+func WithClaimCheck(store BlobStore, threshold int) PublisherOption {
+	if threshold <= 0 {
+		threshold = DefaultClaimCheckThreshold
+	}
+	return publisherOptionFunc(func(o *publisherOptions) {
+		o.claimCheck = &claimCheckOptions{
+			store:     store,
+			threshold: threshold,
+		}
+	})
+}
+
+// WithClaimCheckStore 给 consumer 配置 Claim-Check 的存储
+// store：存储，需要与 publisher 一致
+// deleteAfterAck：消息 ack 之后是否删除存储的内容，多个队列消费同一份存储（例如 fanout）时不能删除
+func WithClaimCheckStore(store BlobStore, deleteAfterAck bool) ConsumerOption {
+	return consumerOptionFunc(func(o *consumerOptions) {
+		o.claimCheck = &claimCheckOptions{
+			store:          store,
+			deleteAfterAck: deleteAfterAck,
+		}
+	})
+}
+
+// checkIn 消息体大于阈值时存入 store，消息只携带引用
+func (o *claimCheckOptions) checkIn(msg *amqp.Publishing) error {
+	if len(msg.Body) <= o.threshold {
+		return nil
+	}
+	ref, err := o.store.Put(context.Background(), msg.Body)
+	if err != nil {
+		return err
+	}
+	headers := make(amqp.Table, len(msg.Headers)+2)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[claimCheckHeader] = ref
+	headers[claimCheckSizeHeader] = int64(len(msg.Body))
+	msg.Headers = headers
+	msg.Body = nil
+	return nil
+}
+
+// claimCheckFetchError 取回消息体时存储暂时不可用，消息重新入队稍后再试
+type claimCheckFetchError struct {
+	err error
+}
+
+func (e *claimCheckFetchError) Error() string {
+	return "claim check: " + e.err.Error()
+}
+
+func (e *claimCheckFetchError) Unwrap() error {
+	return e.err
+}
+
+func claimCheckRef(d *amqp.Delivery) string {
+	ref, _ := d.Headers[claimCheckHeader].(string)
+	return ref
+}
+
+// checkOut 根据引用取回消息体
+func (o *claimCheckOptions) checkOut(d *amqp.Delivery) error {
+	ref := claimCheckRef(d)
+	if ref == "" {
+		return nil
+	}
+	if o == nil {
+		return ClaimCheckStoreNotConfigured
+	}
+	body, err := o.store.Get(context.Background(), ref)
+	if err != nil {
+		if errors.Is(err, BlobNotFound) {
+			return err
+		}
+		return &claimCheckFetchError{err: err}
+	}
+	d.Body = body
+	delete(d.Headers, claimCheckHeader)
+	delete(d.Headers, claimCheckSizeHeader)
+	return nil
+}
+
+// discard 消息发送失败后删除已经存储的内容
+func (o *claimCheckOptions) discard(ref string) {
+	if err := o.store.Delete(context.Background(), ref); err != nil {
+		log.Printf("claim check: delete %s: %s\n", ref, err)
+	}
+}
+
+// release 消息 ack 之后按配置删除存储的内容
+func (o *claimCheckOptions) release(ref string) {
+	if o == nil || !o.deleteAfterAck || ref == "" {
+		return
+	}
+	if err := o.store.Delete(context.Background(), ref); err != nil {
+		log.Printf("claim check: delete %s: %s\n", ref, err)
+	}
+}
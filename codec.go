@@ -0,0 +1,95 @@
+package rbmq
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"sync"
+)
+
+// Codec 消息体的编解码方式，publisher 把 ContentType 写入消息，consumer 根据消息的 ContentType 选择解码方式
+type Codec interface {
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	JSONCodec     Codec = jsonCodec{}
+	ProtobufCodec Codec = protobufCodec{}
+	MsgpackCodec  Codec = msgpackCodec{}
+)
+
+var codecs sync.Map // ContentType -> Codec
+
+func init() {
+	RegisterCodec(JSONCodec)
+	RegisterCodec(ProtobufCodec)
+	RegisterCodec(MsgpackCodec)
+}
+
+// RegisterCodec 注册编解码方式，同一个 ContentType 的编解码方式会被覆盖
+func RegisterCodec(c Codec) {
+	codecs.Store(c.ContentType(), c)
+}
+
+// CodecFor 根据 ContentType 查找已注册的编解码方式
+func CodecFor(contentType string) (Codec, bool) {
+	c, ok := codecs.Load(contentType)
+	if !ok {
+		return nil, false
+	}
+	return c.(Codec), true
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// protobufCodec 要求值实现 proto.Message
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string {
+	return "application/x-protobuf"
+}
+
+func (protobufCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec: %T does not implement proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf codec: %T does not implement proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string {
+	return "application/msgpack"
+}
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	return msgpack.Unmarshal(data, v)
+}
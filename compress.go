@@ -0,0 +1,188 @@
+package rbmq
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/streadway/amqp"
+	"io"
+	"sync"
+)
+
+/*
+关于消息压缩
+publisher 通过 WithCompression 开启压缩，消息体大于等于阈值时压缩后发送，并把压缩算法写入 ContentEncoding；
+小于阈值的消息压缩收益不大，原样发送。
+consumer 不需要任何配置，BaseConsumer 在调用 ConsumeHandler 之前根据 ContentEncoding 自动解压，
+ContentEncoding 不是已注册的压缩算法时（例如其他系统发送的消息）原样交给 ConsumeHandler。
+*/
+
+const (
+	DefaultCompressThreshold = 1024 // 默认压缩阈值，字节
+)
+
+// Compressor 压缩算法
+type Compressor interface {
+	Encoding() string // 写入 ContentEncoding 的算法名
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+var (
+	GzipCompressor   Compressor = gzipCompressor{}
+	ZstdCompressor   Compressor = &zstdCompressor{}
+	SnappyCompressor Compressor = snappyCompressor{}
+)
+
+var compressors sync.Map // ContentEncoding -> Compressor
+
+func init() {
+	RegisterCompressor(GzipCompressor)
+	RegisterCompressor(ZstdCompressor)
+	RegisterCompressor(SnappyCompressor)
+}
+
+// RegisterCompressor 注册压缩算法，consumer 根据 ContentEncoding 查找对应的算法解压，同名的算法会被覆盖
+func RegisterCompressor(c Compressor) {
+	compressors.Store(c.Encoding(), c)
+}
+
+func compressorFor(encoding string) (Compressor, bool) {
+	c, ok := compressors.Load(encoding)
+	if !ok {
+		return nil, false
+	}
+	return c.(Compressor), true
+}
+
+type compressOptions struct {
+	compressor Compressor
+	threshold  int
+}
+
+// WithCompression 给 publisher 开启压缩
+// c：压缩算法
+// threshold：压缩阈值，消息体小于该值时不压缩，小于 0 时使用 DefaultCompressThreshold
+func WithCompression(c Compressor, threshold int) PublisherOption {
+	if threshold < 0 {
+		threshold = DefaultCompressThreshold
+	}
+	return publisherOptionFunc(func(o *publisherOptions) {
+		o.compress = &compressOptions{
+			compressor: c,
+			threshold:  threshold,
+		}
+	})
+}
+
+// compress 按配置压缩消息体，已经设置了 ContentEncoding 的消息不再压缩
+func (o *compressOptions) compress(msg *amqp.Publishing) error {
+	if msg.ContentEncoding != "" || len(msg.Body) < o.threshold {
+		return nil
+	}
+	body, err := o.compressor.Compress(msg.Body)
+	if err != nil {
+		return err
+	}
+	msg.Body = body
+	msg.ContentEncoding = o.compressor.Encoding()
+	return nil
+}
+
+// decompressDelivery 根据 ContentEncoding 解压消息体
+func decompressDelivery(d *amqp.Delivery) error {
+	if d.ContentEncoding == "" {
+		return nil
+	}
+	c, ok := compressorFor(d.ContentEncoding)
+	if !ok {
+		return nil
+	}
+	body, err := c.Decompress(d.Body)
+	if err != nil {
+		return fmt.Errorf("decompress %s: %w", d.ContentEncoding, err)
+	}
+	d.Body = body
+	d.ContentEncoding = ""
+	return nil
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Encoding() string {
+	return "gzip"
+}
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// zstdCompressor 编码器和解码器创建开销较大，全局复用，EncodeAll、DecodeAll 可以并发调用
+type zstdCompressor struct {
+	once    sync.Once
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+	err     error
+}
+
+func (z *zstdCompressor) init() error {
+	z.once.Do(func() {
+		z.encoder, z.err = zstd.NewWriter(nil)
+		if z.err != nil {
+			return
+		}
+		z.decoder, z.err = zstd.NewReader(nil)
+	})
+	return z.err
+}
+
+func (z *zstdCompressor) Encoding() string {
+	return "zstd"
+}
+
+func (z *zstdCompressor) Compress(data []byte) ([]byte, error) {
+	if err := z.init(); err != nil {
+		return nil, err
+	}
+	return z.encoder.EncodeAll(data, nil), nil
+}
+
+func (z *zstdCompressor) Decompress(data []byte) ([]byte, error) {
+	if err := z.init(); err != nil {
+		return nil, err
+	}
+	return z.decoder.DecodeAll(data, nil)
+}
+
+type snappyCompressor struct{}
+
+func (snappyCompressor) Encoding() string {
+	return "snappy"
+}
+
+func (snappyCompressor) Compress(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+func (snappyCompressor) Decompress(data []byte) ([]byte, error) {
+	return snappy.Decode(nil, data)
+}
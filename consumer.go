@@ -1,10 +1,15 @@
 package rbmq
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/streadway/amqp"
 	"log"
 	"os"
 	"runtime/debug"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -26,38 +31,305 @@ message 继续呆在 queue 中。之后其中 consumer 对一条消息进行 ack
 就投递到哪个 consumer 中。
 
 总的来说，consumer 负责不断处理消息，不断 ack，然后只要 unacked 数少于 prefetch * consumer 数目，broker 就不断将消息投递过去。
+
+(3)prefetch 与并发
+默认情况下 consumer 串行调用处理函数，prefetch 的消息只是缓存在本地，一条慢消息会阻塞后面所有的消息。
+通过 WithConcurrency 开启 N 个 worker goroutine 并发处理，每条消息由处理它的 worker 单独 ack，
+prefetch 会被提升到不小于 N，否则 worker 等不到消息。并发处理时不再保证消息的处理顺序。
 */
 
 const (
 	DefaultPrefetchCount = 20
+
+	concurrencyPrefetch = -1 // 并发数与 prefetch 相同
 )
 
 type ConsumeHandler func(payload []byte) error
 
+// rejectError 处理函数返回该错误时，消息不会重新入队，而是直接拒绝
+type rejectError struct {
+	err error
+}
+
+func (e *rejectError) Error() string {
+	return "reject: " + e.err.Error()
+}
+
+func (e *rejectError) Unwrap() error {
+	return e.err
+}
+
+// Reject 包装处理函数的错误，表示该消息重试也无法处理（例如格式错误），不再重新入队，配置了死信的队列会转入死信
+func Reject(err error) error {
+	return &rejectError{err: err}
+}
+
+// PanicError 处理函数 panic 时作为处理失败的错误，与其他错误一样 nack 或重试
+type PanicError struct {
+	Value interface{} // recover 的值
+	Stack []byte      // panic 时的调用栈
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panic: %v", e.Value)
+}
+
+// ErrorHook 消息处理失败（包括消息体无法还原、处理函数返回错误或 panic）时调用，用于记录日志和告警
+type ErrorHook func(msg *Message, err error)
+
+// WithErrorHook 指定消息处理失败时的回调
+func WithErrorHook(hook ErrorHook) ConsumerOption {
+	return consumerOptionFunc(func(o *consumerOptions) {
+		o.errorHook = hook
+	})
+}
+
+// ConsumerState consumer 的状态
+type ConsumerState int32
+
+const (
+	ConsumerIdle         ConsumerState = iota // 创建后还没有开始监听
+	ConsumerRunning                           // 正在监听
+	ConsumerReconnecting                      // 连接断开，等待重连
+	ConsumerStopped                           // 已经停止，可以再次开始
+)
+
+func (s ConsumerState) String() string {
+	switch s {
+	case ConsumerIdle:
+		return "idle"
+	case ConsumerRunning:
+		return "running"
+	case ConsumerReconnecting:
+		return "reconnecting"
+	case ConsumerStopped:
+		return "stopped"
+	}
+	return fmt.Sprintf("ConsumerState(%d)", int32(s))
+}
+
 type IConsumer interface {
-	Consume(handler ConsumeHandler) (err error) // 该方法会阻塞调用，建议开启一个单独的 goroutine 调用
-	Stop()                                      // 停止监听，注意不会关闭连接，因为连接可能不是独占的
+	Consume(handler ConsumeHandler) (err error)                               // 该方法会阻塞调用，建议开启一个单独的 goroutine 调用
+	ConsumeMessage(handler MessageHandler) (err error)                        // 与 Consume 相同，处理函数可以拿到消息元数据
+	ConsumeBatch(size int, maxWait time.Duration, handler BatchHandler) error // 批量监听消费，该方法会阻塞调用
+	Start(handler MessageHandler) error                                       // 在后台开始监听，已经在监听时不做任何事
+	Stop(ctx context.Context) error                                           // 停止监听并等待处理中的消息完成，注意不会关闭连接，因为连接可能不是独占的
+	Restart(ctx context.Context) error                                        // 停止后使用上一次的处理函数重新开始监听
+	State() ConsumerState                                                     // 当前状态
+}
+
+// ConsumerOption consumer 的可选配置，在创建 consumer 时传入
+type ConsumerOption interface {
+	applyConsumer(*consumerOptions)
+}
+
+type consumerOptionFunc func(*consumerOptions)
+
+func (f consumerOptionFunc) applyConsumer(o *consumerOptions) {
+	f(o)
+}
+
+type consumerOptions struct {
+	keyProvider KeyProvider        // 解密消息使用的密钥，为空时不解密
+	verify      *verifyOptions     // 验签，为空时不验签
+	claimCheck  *claimCheckOptions // Claim-Check 的存储，为空时不取回消息体
+	queue       *QueueOptions      // 申请队列的参数
+	exchange    *ExchangeOptions   // 申请交换机的参数
+	prefetch    int                // prefetch，为 0 时使用构造函数的默认值
+	concurrency int                // 并发处理的 worker 数，为 0 时串行处理
+	retry       *RetryPolicy       // 重试策略，为空时 nack 并重新入队
+	deadLetter  bool               // 申请队列时同时申请死信队列
+	errorHook   ErrorHook          // 消息处理失败时调用
+
+	middlewares    []Middleware   // 处理函数的中间件
+	handlerTimeout time.Duration  // 处理函数的超时，为 0 时不超时
+	timeoutOutcome TimeoutOutcome // 超时后消息的处理方式
+	pullMinBackoff time.Duration  // PullConsumer 队列为空时的最小轮询间隔
+	pullMaxBackoff time.Duration  // PullConsumer 队列为空时的最大轮询间隔
+}
+
+// WithPrefetchCount 指定 consumer 的 prefetch，覆盖构造函数的默认值 DefaultPrefetchCount
+func WithPrefetchCount(n int) ConsumerOption {
+	return consumerOptionFunc(func(o *consumerOptions) {
+		o.prefetch = n
+	})
+}
+
+// WithConcurrency 指定并发处理消息的 worker 数，不指定时串行处理
+// n：worker 数，小于等于 0 时与 prefetch 相同；大于 prefetch 时 prefetch 会被提升到 n
+func WithConcurrency(n int) ConsumerOption {
+	return consumerOptionFunc(func(o *consumerOptions) {
+		if n <= 0 {
+			n = concurrencyPrefetch
+		}
+		o.concurrency = n
+	})
 }
 
 type BaseConsumer struct {
 	iC            IConsumer
 	mqConn        *RMQConn //连接
 	prefetchCount int
-	queueName     string        // 队列名
-	stopChan      chan struct{} // 停止监听
+	concurrency   int    // 并发处理的 worker 数
+	queueName     string // 队列名
+	opts          consumerOptions
+
+	// 以下字段在 mu 保护下修改，每次开始监听时重新创建 stopChan、done、cancel，所以停止后可以再次开始
+	mu       sync.Mutex
+	state    atomic.Int32       // ConsumerState
+	mode     *consumeMode       // 最近一次监听的处理方式，用于 Restart
+	stopChan chan struct{}      // 停止监听
+	stopping bool               // stopChan 是否已经关闭
+	done     chan struct{}      // 监听返回后关闭
+	cancel   context.CancelFunc // 取消正在处理的消息的 ctx
+
+	consumeArgs func() amqp.Table     // 每次（重新）监听时的参数，为空时没有参数
+	onAck       func(d amqp.Delivery) // 消息 ack 成功后调用
+
+	retryQueues        sync.Map    // 已经申请过的重试队列，key 为延时毫秒数
+	deadLetterDeclared atomic.Bool // 是否已经申请过死信队列
 }
 
-func NewBaseConsumer(conn *RMQConn, prefetchCount int, queueName string, iC IConsumer) *BaseConsumer {
+func newConsumerOptions(opts []ConsumerOption) consumerOptions {
+	var o consumerOptions
+	for _, opt := range opts {
+		opt.applyConsumer(&o)
+	}
+	return o
+}
+
+func NewBaseConsumer(conn *RMQConn, prefetchCount int, queueName string, iC IConsumer, opts ...ConsumerOption) *BaseConsumer {
+	o := newConsumerOptions(opts)
+	if o.prefetch > 0 {
+		prefetchCount = o.prefetch
+	}
+	concurrency := 1
+	switch {
+	case o.concurrency == concurrencyPrefetch:
+		concurrency = prefetchCount
+	case o.concurrency > 0:
+		concurrency = o.concurrency
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if prefetchCount < concurrency {
+		prefetchCount = concurrency
+	}
 	return &BaseConsumer{
 		iC:            iC,
 		mqConn:        conn,
 		prefetchCount: prefetchCount,
+		concurrency:   concurrency,
 		queueName:     queueName,
-		stopChan:      make(chan struct{}),
+		opts:          o,
 	}
 }
 
 func (c *BaseConsumer) Consume(handler ConsumeHandler) (err error) {
+	return c.ConsumeMessage(ConsumeHandlerAdapter(handler))
+}
+
+// consumeMode 一次监听的处理方式，逐条处理或者批量处理
+type consumeMode struct {
+	prefetch   int
+	dispatcher func(ctx context.Context, fault *workerFault) (dispatch func(d amqp.Delivery), wait func())
+}
+
+// messageMode 逐条处理
+func (c *BaseConsumer) messageMode(handler MessageHandler) *consumeMode {
+	handler = ChainMiddleware(c.opts.middlewares...)(handler)
+	return &consumeMode{
+		prefetch: c.prefetchCount,
+		dispatcher: func(ctx context.Context, fault *workerFault) (func(d amqp.Delivery), func()) {
+			return c.dispatcher(ctx, handler, fault)
+		},
+	}
+}
+
+// ConsumeMessage 监听消费，断网时等待重连后继续监听，已经在监听时返回 ConsumerIsRunning
+func (c *BaseConsumer) ConsumeMessage(handler MessageHandler) (err error) {
+	return c.consume(c.messageMode(handler))
+}
+
+// consume 阻塞监听
+func (c *BaseConsumer) consume(mode *consumeMode) error {
+	ctx, ok := c.begin(mode)
+	if !ok {
+		return ConsumerIsRunning
+	}
+	return c.run(ctx, mode)
+}
+
+// Start 在后台开始监听，已经在监听时不做任何事，监听返回的错误只记录日志
+func (c *BaseConsumer) Start(handler MessageHandler) error {
+	return c.start(c.messageMode(handler))
+}
+
+func (c *BaseConsumer) start(mode *consumeMode) error {
+	ctx, ok := c.begin(mode)
+	if !ok {
+		return nil
+	}
+	go func() {
+		if err := c.run(ctx, mode); err != nil {
+			log.Printf("consumer %s: %s\n", c.queueName, err)
+		}
+	}()
+	return nil
+}
+
+// Restart 停止后使用上一次的处理函数重新在后台开始监听，Stop 超时时不会重新开始
+func (c *BaseConsumer) Restart(ctx context.Context) error {
+	if err := c.Stop(ctx); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	mode := c.mode
+	c.mu.Unlock()
+	if mode == nil {
+		return ConsumerNeverStarted
+	}
+	return c.start(mode)
+}
+
+// State 返回 consumer 当前的状态
+func (c *BaseConsumer) State() ConsumerState {
+	return ConsumerState(c.state.Load())
+}
+
+// begin 没有在监听时准备本次监听需要的状态，返回处理函数的 ctx，已经在监听时返回 false
+// ctx 在 Stop 超时或者监听返回时取消
+func (c *BaseConsumer) begin(mode *consumeMode) (context.Context, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.done != nil {
+		return nil, false
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.mode = mode
+	c.stopChan = make(chan struct{})
+	c.stopping = false
+	c.done = make(chan struct{})
+	c.cancel = cancel
+	c.state.Store(int32(ConsumerRunning))
+	return ctx, true
+}
+
+// run 监听消费直到停止或者出错
+func (c *BaseConsumer) run(ctx context.Context, mode *consumeMode) (err error) {
+	c.mu.Lock()
+	stopChan, done, cancel := c.stopChan, c.done, c.cancel
+	c.mu.Unlock()
+	defer func() {
+		cancel()
+		c.mu.Lock()
+		c.done, c.cancel = nil, nil
+		c.state.Store(int32(ConsumerStopped))
+		c.mu.Unlock()
+		close(done)
+	}()
+
 	defer func() {
 		if pErr := recover(); pErr != nil {
 			fmt.Fprintln(os.Stderr, pErr)
@@ -70,21 +342,27 @@ func (c *BaseConsumer) Consume(handler ConsumeHandler) (err error) {
 	}()
 Recon:
 	var isConnClosed bool
-	isConnClosed, err = c.consumeHandle(handler)
+	isConnClosed, err = c.consumeHandle(ctx, stopChan, mode)
 	if err != nil {
 		return err
 	}
 	// 如果是连接被关闭才返回，且是异常断网，则等待重连后继续监听消费
 	if isConnClosed && !c.mqConn.IsnNormalClose() {
+		c.state.Store(int32(ConsumerReconnecting))
 		for c.mqConn.GetConn().IsClosed() {
-			time.Sleep(time.Second)
+			select {
+			case <-stopChan:
+				return nil
+			case <-time.After(time.Second):
+			}
 		}
+		c.state.Store(int32(ConsumerRunning))
 		goto Recon
 	}
 	return nil
 }
 
-func (c *BaseConsumer) consumeHandle(handler ConsumeHandler) (bool, error) {
+func (c *BaseConsumer) consumeHandle(ctx context.Context, stopChan <-chan struct{}, mode *consumeMode) (bool, error) {
 	channel, err := c.mqConn.GetConn().Channel()
 	if err != nil {
 		return false, err
@@ -92,44 +370,56 @@ func (c *BaseConsumer) consumeHandle(handler ConsumeHandler) (bool, error) {
 	defer channel.Close()
 
 	err = channel.Qos(
-		c.prefetchCount, // prefetch count
-		0,               // prefetch size
-		false,           // global
+		mode.prefetch, // prefetch count
+		0,             // prefetch size
+		false,         // global
 	)
 	if err != nil {
 		return false, err
 	}
 
+	var args amqp.Table
+	if c.consumeArgs != nil {
+		args = c.consumeArgs()
+	}
+
+	consumerTag, err := newConsumerTag()
+	if err != nil {
+		return false, err
+	}
+
 	// 消费消息
 	deliveryChan, err := channel.Consume(
 		c.queueName, // 引用前面的队列名
-		"",          // 消费者名字，不填自动生成一个
+		consumerTag, // 消费者名字，停止监听时用于 basic.cancel
 		false,       // 自动向队列确认消息已经处理
 		false,       // exclusive
 		false,       // no-local
 		false,       // no-wait
-		nil,         // args
+		args,        // args
 	)
 	if err != nil {
 		return false, err
 	}
+
+	// 返回前等待 worker 处理完已分发的消息，在关闭 channel 之前完成 ack
+	tracker := newAckTracker(channel)
+	fault := newWorkerFault()
+	dispatch, wait := mode.dispatcher(ctx, fault)
+	defer wait()
 	for {
 		select {
-		case <-c.stopChan:
+		case <-stopChan:
 			log.Println("consumeHandle：consumer quit listen msg！")
+			c.cancelConsume(channel, consumerTag, deliveryChan)
 			return false, nil
+		case <-fault.done:
+			// 与串行处理时 panic 一样返回错误，没有 ack 的消息在关闭 channel 时重新入队
+			return false, fault.err
 		case d, ok := <-deliveryChan:
 			if ok {
-				err = handler(d.Body)
-				if err != nil {
-					if err = d.Nack(false, true); err != nil {
-						log.Printf("deliver.Nack: %s\n", err)
-					}
-				} else {
-					if err = d.Ack(false); err != nil {
-						log.Printf("deliver.Ack: %s\n", err)
-					}
-				}
+				tracker.track(&d)
+				dispatch(d)
 			} else {
 				// 通道被关闭，可能是异常断网，也可能是正常关闭网络
 				log.Println("consumeHandle：deliveryChan closed！")
@@ -139,6 +429,287 @@ func (c *BaseConsumer) consumeHandle(handler ConsumeHandler) (bool, error) {
 	}
 }
 
-func (c *BaseConsumer) Stop() {
-	close(c.stopChan)
+// cancelConsume 通知 broker 不再投递新消息，已经预取到本地还没有处理的消息 nack 并重新入队
+func (c *BaseConsumer) cancelConsume(channel *amqp.Channel, consumerTag string, deliveryChan <-chan amqp.Delivery) {
+	if err := channel.Cancel(consumerTag, false); err != nil {
+		// 通道已经关闭，未 ack 的消息会由 broker 重新入队
+		log.Printf("consumeHandle: cancel: %s\n", err)
+		return
+	}
+	// basic.cancel 完成后 deliveryChan 把缓存的消息读完后关闭
+	for d := range deliveryChan {
+		if err := d.Nack(false, true); err != nil {
+			log.Printf("deliver.Nack: %s\n", err)
+		}
+	}
+}
+
+// newConsumerTag 生成唯一的消费者名
+func newConsumerTag() (string, error) {
+	id, err := newCorrelationId()
+	if err != nil {
+		return "", err
+	}
+	return "rbmq-" + id, nil
+}
+
+// dispatcher 返回分发消息的函数和等待分发的消息处理完的函数
+// 串行时直接在监听的 goroutine 中处理，并发时分发给 concurrency 个 worker，所有 worker 都在忙时阻塞，不再从 deliveryChan 读取
+// 串行时处理函数之外的 panic 由 run 恢复，并发时由 worker 恢复后通过 fault 通知 consumer 返回错误
+func (c *BaseConsumer) dispatcher(ctx context.Context, handler MessageHandler, fault *workerFault) (dispatch func(d amqp.Delivery), wait func()) {
+	if c.concurrency <= 1 {
+		return func(d amqp.Delivery) {
+			c.handleDelivery(ctx, d, handler)
+		}, func() {}
+	}
+	jobs := make(chan amqp.Delivery)
+	var wg sync.WaitGroup
+	wg.Add(c.concurrency)
+	for i := 0; i < c.concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			var d amqp.Delivery
+			defer func() {
+				if pErr := recover(); pErr != nil {
+					fault.panicked(pErr, &d)
+				}
+			}()
+			for d = range jobs {
+				c.handleDelivery(ctx, d, handler)
+			}
+		}()
+	}
+	dispatch = func(d amqp.Delivery) {
+		select {
+		case jobs <- d:
+		case <-fault.done:
+			// worker 已经 panic，consumer 即将返回错误，消息在关闭 channel 时重新入队
+		}
+	}
+	wait = func() {
+		close(jobs)
+		wg.Wait()
+	}
+	return dispatch, wait
+}
+
+// workerFault 记录 worker goroutine 中处理函数之外的 panic（还原消息体、ErrorHook、onAck 等）
+type workerFault struct {
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+func newWorkerFault() *workerFault {
+	return &workerFault{done: make(chan struct{})}
+}
+
+// panicked 记录日志，nack 正在处理的还没有确认的消息并重新入队，然后通知 consumer 返回错误，只能在 recover 之后调用
+func (f *workerFault) panicked(pErr interface{}, ds ...*amqp.Delivery) {
+	buf := debug.Stack()
+	log.Printf("pErr:%v.stack:%s", pErr, string(buf))
+	for _, d := range ds {
+		if t, ok := d.Acknowledger.(*ackTracker); ok && !t.pending(d.DeliveryTag) {
+			continue
+		}
+		if err := d.Nack(false, true); err != nil {
+			log.Printf("deliver.Nack: %s\n", err)
+		}
+	}
+	f.once.Do(func() {
+		f.err = fmt.Errorf("%v", pErr)
+		close(f.done)
+	})
+}
+
+// ackTracker 记录已经投递但还没有 ack 或 nack 的消息，worker panic 时只 nack 还没有确认的消息，重复确认会导致 broker 关闭通道
+type ackTracker struct {
+	amqp.Acknowledger
+	mu      sync.Mutex
+	unacked map[uint64]struct{}
+}
+
+func newAckTracker(a amqp.Acknowledger) *ackTracker {
+	return &ackTracker{
+		Acknowledger: a,
+		unacked:      make(map[uint64]struct{}),
+	}
+}
+
+// track 记录消息，并让消息通过 tracker 确认
+func (t *ackTracker) track(d *amqp.Delivery) {
+	t.mu.Lock()
+	t.unacked[d.DeliveryTag] = struct{}{}
+	t.mu.Unlock()
+	d.Acknowledger = t
+}
+
+func (t *ackTracker) pending(tag uint64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.unacked[tag]
+	return ok
+}
+
+func (t *ackTracker) settle(tag uint64, multiple bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !multiple {
+		delete(t.unacked, tag)
+		return
+	}
+	for k := range t.unacked {
+		if k <= tag {
+			delete(t.unacked, k)
+		}
+	}
+}
+
+func (t *ackTracker) Ack(tag uint64, multiple bool) error {
+	t.settle(tag, multiple)
+	return t.Acknowledger.Ack(tag, multiple)
+}
+
+func (t *ackTracker) Nack(tag uint64, multiple, requeue bool) error {
+	t.settle(tag, multiple)
+	return t.Acknowledger.Nack(tag, multiple, requeue)
+}
+
+func (t *ackTracker) Reject(tag uint64, requeue bool) error {
+	t.settle(tag, false)
+	return t.Acknowledger.Reject(tag, requeue)
+}
+
+// inflight 已经还原消息体、等待处理结果的消息
+type inflight struct {
+	d   amqp.Delivery // 还原后的消息
+	raw amqp.Delivery // 原始消息，重试时发送
+	ref string        // Claim-Check 的引用
+	msg *Message
+}
+
+// handleDelivery 还原消息体后调用处理函数，并根据处理结果 ack 或 nack
+func (c *BaseConsumer) handleDelivery(ctx context.Context, d amqp.Delivery, handler MessageHandler) {
+	f, ok := c.receive(d)
+	if !ok {
+		return
+	}
+	if err := c.call(ctx, f.msg, handler); err != nil {
+		c.fail(f, err)
+		return
+	}
+	if err := f.d.Ack(false); err != nil {
+		log.Printf("deliver.Ack: %s\n", err)
+		return
+	}
+	c.acked(f)
+}
+
+// receive 还原消息体，无法还原的消息直接 nack，返回 false
+func (c *BaseConsumer) receive(d amqp.Delivery) (*inflight, bool) {
+	f := &inflight{ref: claimCheckRef(&d)}
+	// 还原消息体会修改消息头，重试时需要发送原始消息
+	f.raw = d
+	if c.opts.retry != nil {
+		f.raw.Headers = make(amqp.Table, len(d.Headers))
+		for k, v := range d.Headers {
+			f.raw.Headers[k] = v
+		}
+	}
+	if err := c.opts.decode(&d); err != nil {
+		log.Printf("consumeHandle: %s\n", err)
+		c.onError(newMessage(&d), err)
+		// 存储暂时不可用时重新入队稍后再试，其他无法还原的消息重新入队也处理不了，直接拒绝，配置了死信的队列会转入死信
+		var fErr *claimCheckFetchError
+		if err = d.Nack(false, errors.As(err, &fErr)); err != nil {
+			log.Printf("deliver.Nack: %s\n", err)
+		}
+		return nil, false
+	}
+	f.d = d
+	f.msg = newMessage(&d)
+	return f, true
+}
+
+// fail 处理失败，按重试策略重试，没有重试策略时 nack，Reject 包装的错误不重新入队
+func (c *BaseConsumer) fail(f *inflight, err error) {
+	c.onError(f.msg, err)
+	if errors.Is(err, HandlerTimeout) {
+		c.timedOut(f, err)
+		return
+	}
+	if c.opts.retry != nil {
+		c.retry(f.raw, err)
+		return
+	}
+	var rErr *rejectError
+	if err = f.d.Nack(false, !errors.As(err, &rErr)); err != nil {
+		log.Printf("deliver.Nack: %s\n", err)
+	}
+}
+
+// acked 消息 ack 之后的清理
+func (c *BaseConsumer) acked(f *inflight) {
+	c.opts.claimCheck.release(f.ref)
+	if c.onAck != nil {
+		c.onAck(f.d)
+	}
+}
+
+// invoke 调用处理函数，处理函数 panic 时恢复并作为处理失败，不影响其他消息
+func (c *BaseConsumer) invoke(fn func() error) (err error) {
+	defer func() {
+		if pErr := recover(); pErr != nil {
+			pe := &PanicError{Value: pErr, Stack: debug.Stack()}
+			log.Printf("pErr:%v.stack:%s", pErr, string(pe.Stack))
+			err = pe
+		}
+	}()
+	return fn()
+}
+
+// onError 调用 ErrorHook
+func (c *BaseConsumer) onError(msg *Message, err error) {
+	if c.opts.errorHook != nil {
+		c.opts.errorHook(msg, err)
+	}
+}
+
+// decode 在调用处理函数之前还原消息体，顺序与 publisher 处理的顺序相反
+func (o *consumerOptions) decode(d *amqp.Delivery) error {
+	if err := o.claimCheck.checkOut(d); err != nil {
+		return err
+	}
+	if err := o.verify.verifyDelivery(d); err != nil {
+		return err
+	}
+	if err := decryptDelivery(d, o.keyProvider); err != nil {
+		return err
+	}
+	return decompressDelivery(d)
+}
+
+// Stop 停止监听：发送 basic.cancel 不再接收新消息，等待处理中的消息完成并 ack，预取到本地还没有处理的消息重新入队
+// ctx：等待的超时，超时后取消处理中的消息的 ctx 并返回 ctx.Err()，处理函数返回后消息仍然会正常 ack 或 nack
+// 没有在监听时不做任何事，可以在多个 goroutine 中同时调用
+func (c *BaseConsumer) Stop(ctx context.Context) error {
+	c.mu.Lock()
+	done, cancel := c.done, c.cancel
+	if done == nil {
+		c.mu.Unlock()
+		return nil
+	}
+	if !c.stopping {
+		close(c.stopChan)
+		c.stopping = true
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		cancel()
+		return ctx.Err()
+	}
 }
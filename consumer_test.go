@@ -0,0 +1,85 @@
+package rbmq
+
+import (
+	"context"
+	"errors"
+	"github.com/streadway/amqp"
+	"testing"
+	"time"
+)
+
+func TestAckTracker(t *testing.T) {
+	tracker := newAckTracker(&fakeAcknowledger{})
+	ds := make([]amqp.Delivery, 5)
+	for i := range ds {
+		ds[i].DeliveryTag = uint64(i + 1)
+		tracker.track(&ds[i])
+	}
+	if err := ds[1].Nack(false, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds[3].Ack(true); err != nil {
+		t.Fatal(err)
+	}
+	for tag, want := range map[uint64]bool{1: false, 2: false, 3: false, 4: false, 5: true} {
+		if got := tracker.pending(tag); got != want {
+			t.Errorf("pending(%d) = %v, want %v", tag, got, want)
+		}
+	}
+}
+
+func TestWorkerPanic(t *testing.T) {
+	panics := func() { panic("boom") }
+	tests := []struct {
+		name       string
+		handlerErr error
+		hook       ErrorHook
+		onAck      func(d amqp.Delivery)
+		wantAcked  int
+		wantNacked int
+	}{
+		{
+			name:       "error hook panics before nack",
+			handlerErr: errors.New("failed"),
+			hook:       func(msg *Message, err error) { panics() },
+			wantNacked: 1,
+		},
+		{
+			name:      "onAck panics after ack",
+			onAck:     func(d amqp.Delivery) { panics() },
+			wantAcked: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ack := &fakeAcknowledger{}
+			tracker := newAckTracker(ack)
+			c := &BaseConsumer{
+				concurrency: 2,
+				opts:        consumerOptions{errorHook: tt.hook},
+				onAck:       tt.onAck,
+			}
+			fault := newWorkerFault()
+			handler := func(ctx context.Context, msg *Message) error { return tt.handlerErr }
+			dispatch, wait := c.dispatcher(context.Background(), handler, fault)
+
+			d := amqp.Delivery{DeliveryTag: 1}
+			tracker.track(&d)
+			dispatch(d)
+			select {
+			case <-fault.done:
+			case <-time.After(time.Second):
+				t.Fatal("worker panic is not reported")
+			}
+			wait()
+
+			if fault.err == nil {
+				t.Error("fault.err is nil")
+			}
+			// 已经 ack 的消息不能再 nack，否则 broker 会关闭通道
+			if len(ack.acked) != tt.wantAcked || len(ack.nacked) != tt.wantNacked {
+				t.Errorf("acked %v, nacked %v, want %d acked, %d nacked", ack.acked, ack.nacked, tt.wantAcked, tt.wantNacked)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package rbmq
+
+import (
+	"fmt"
+	"github.com/streadway/amqp"
+	"time"
+)
+
+/*
+关于死信队列
+通过 WithDeadLetterQueue 在申请队列时同时申请死信队列 <queue>.dlq，并给队列设置 x-dead-letter-exchange 为默认交换机、
+x-dead-letter-routing-key 为死信队列名，被拒绝（Reject 或 nack 不重新入队）、过期、超过最大长度的消息由 broker 转入死信队列，
+broker 会在消息头 x-death 中记录原因、原来的队列、交换机和路由 key。
+配置了 WithRetryPolicy 时，重试次数用完的消息由 consumer 发送到同一个死信队列，原来的交换机和路由 key 记录在
+x-original-exchange、x-original-routing-key 中。
+
+RMQConn.ListDeadLetters 查看死信队列中的消息，RMQConn.RedriveDeadLetters 把选中的消息重新发送到原来的交换机和路由 key，
+重新发送时会去掉死信和重试相关的消息头，消息重新开始计算重试次数。重新发送使用 publisher confirm 和 mandatory，
+broker 确认收到后才从死信队列中删除；原来的路由已经不存在（队列被删除、绑定被修改）时消息会被退回，
+此时消息留在死信队列中并返回 MessageUnroutable。
+注意：队列名为空（由 broker 生成）以及 stream 队列不支持死信队列，publisher 与 consumer 需要同时指定 WithDeadLetterQueue，
+否则队列参数不一致，rabbitmq 会返回 PRECONDITION_FAILED。
+*/
+
+const (
+	deathHeader = "x-death"
+
+	DeadLetterReasonFailed = "failed" // 由重试策略转入死信队列
+)
+
+// WithDeadLetterQueue 申请队列时同时申请死信队列，并把队列的死信转入该死信队列
+func WithDeadLetterQueue() Option {
+	return deadLetterOption{}
+}
+
+type deadLetterOption struct{}
+
+func (o deadLetterOption) applyPublisher(p *publisherOptions) {
+	p.deadLetter = true
+}
+
+func (o deadLetterOption) applyConsumer(c *consumerOptions) {
+	c.deadLetter = true
+}
+
+// deadLetterArguments 申请队列对应的死信队列，并在队列参数中加入死信配置
+// queueName：队列名，为空时不支持死信队列
+// args：队列的其他参数
+func deadLetterArguments(channel *amqp.Channel, queueName string, args amqp.Table) (amqp.Table, error) {
+	if queueName == "" {
+		return nil, fmt.Errorf("%w: dead letter queue requires a queue name", QueueOptionsInvalid)
+	}
+	if args["x-queue-type"] == string(QueueTypeStream) {
+		return nil, fmt.Errorf("%w: stream queue does not support dead lettering", QueueOptionsInvalid)
+	}
+	if _, ok := args["x-dead-letter-exchange"]; ok {
+		return nil, fmt.Errorf("%w: dead letter exchange is already set", QueueOptionsInvalid)
+	}
+	name, err := declareDeadLetterQueue(channel, queueName)
+	if err != nil {
+		return nil, err
+	}
+	table := make(amqp.Table, len(args)+2)
+	for k, v := range args {
+		table[k] = v
+	}
+	table["x-dead-letter-exchange"] = ""
+	table["x-dead-letter-routing-key"] = name
+	return table, nil
+}
+
+// declareDeadLetterQueue 申请队列对应的死信队列，返回死信队列名
+func declareDeadLetterQueue(channel *amqp.Channel, queueName string) (string, error) {
+	name := DeadLetterQueueName(queueName)
+	_, err := channel.QueueDeclare(
+		name,
+		true,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// DeadLetter 死信队列中的消息
+// Body 为 publisher 发送的原始消息体，没有解压、解密
+type DeadLetter struct {
+	*Message
+	Reason             string    // 转入死信的原因：rejected、expired、maxlen、delivery_limit，由重试策略转入的为 failed
+	Queue              string    // 原来的队列
+	OriginalExchange   string    // 原来的交换机
+	OriginalRoutingKey string    // 原来的路由 key
+	Count              int64     // 因为该原因从该队列转入死信的次数
+	Time               time.Time // 第一次转入死信的时间，由重试策略转入的没有该值
+	Attempts           int       // 由重试策略转入时已经处理的次数
+	LastError          string    // 由重试策略转入时最后一次处理的错误
+}
+
+// newDeadLetter 从死信队列的消息中解析死信信息
+// 重试过的消息带有重试策略记录的消息头，经过重试队列时 broker 还会因为 TTL 过期写入 x-death，
+// 所以最近一次死信发生在重试队列（或者没有 x-death）时才是由重试策略转入，否则是 broker 从原队列转入，以 x-death 为准
+func newDeadLetter(d *amqp.Delivery, queueName string) *DeadLetter {
+	dl := &DeadLetter{
+		Message: newMessage(d),
+		Queue:   queueName,
+	}
+	var death amqp.Table
+	if deaths, ok := d.Headers[deathHeader].([]interface{}); ok && len(deaths) > 0 {
+		// 最近一次死信的记录在最前面
+		death, _ = deaths[0].(amqp.Table)
+	}
+	_, hasRetry := d.Headers[retryAttemptHeader]
+	_, hasOriginal := d.Headers[originalExchangeHeader]
+	if (hasRetry || hasOriginal) && (death == nil || isRetryQueue(stringHeader(death, "queue"), queueName)) {
+		dl.Reason = DeadLetterReasonFailed
+		dl.OriginalExchange = stringHeader(d.Headers, originalExchangeHeader)
+		dl.OriginalRoutingKey = stringHeader(d.Headers, originalRoutingKeyHeader)
+		dl.Count = 1
+		dl.Attempts = retryAttempt(d.Headers)
+		dl.LastError = stringHeader(d.Headers, lastErrorHeader)
+		return dl
+	}
+	if death != nil {
+		dl.Reason = stringHeader(death, "reason")
+		dl.Queue = stringHeader(death, "queue")
+		dl.OriginalExchange = stringHeader(death, "exchange")
+		if keys, ok := death["routing-keys"].([]interface{}); ok && len(keys) > 0 {
+			dl.OriginalRoutingKey, _ = keys[0].(string)
+		}
+		dl.Count, _ = death["count"].(int64)
+		dl.Time, _ = death["time"].(time.Time)
+	}
+	if hasOriginal {
+		// 重试过的消息由重试队列经默认交换机投递回原队列，x-death 中记录的是这一跳，原来的路由以重试策略记录的为准
+		dl.OriginalExchange = stringHeader(d.Headers, originalExchangeHeader)
+		dl.OriginalRoutingKey = stringHeader(d.Headers, originalRoutingKeyHeader)
+	}
+	return dl
+}
+
+func stringHeader(headers amqp.Table, key string) string {
+	s, _ := headers[key].(string)
+	return s
+}
+
+// ListDeadLetters 查看队列对应的死信队列中的消息，消息不会被删除
+// queueName：原来的队列名
+// max：最多返回的条数，小于等于 0 时返回全部
+func (r *RMQConn) ListDeadLetters(queueName string, max int) ([]*DeadLetter, error) {
+	if queueName == "" {
+		return nil, QueueNameIsEmpty
+	}
+	channel, err := r.GetConn().Channel()
+	if err != nil {
+		return nil, err
+	}
+	// 取出的消息没有 ack，关闭通道时全部重新入队
+	defer channel.Close()
+
+	dlq := DeadLetterQueueName(queueName)
+	var list []*DeadLetter
+	for max <= 0 || len(list) < max {
+		d, ok, err := channel.Get(dlq, false)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		list = append(list, newDeadLetter(&d, queueName))
+	}
+	return list, nil
+}
+
+// RedriveDeadLetters 把死信队列中选中的消息重新发送到原来的交换机和路由 key，返回重新发送的条数
+// 发送失败时停止，失败的消息留在死信队列中
+// queueName：原来的队列名
+// filter：选择需要重新发送的消息，为空时重新发送全部，没有选中的消息留在死信队列中
+func (r *RMQConn) RedriveDeadLetters(queueName string, filter func(dl *DeadLetter) bool) (int, error) {
+	if queueName == "" {
+		return 0, QueueNameIsEmpty
+	}
+	channel, err := r.GetConn().Channel()
+	if err != nil {
+		return 0, err
+	}
+	// 没有选中以及发送失败的消息不 ack，关闭通道时重新入队，本次不会再取到
+	defer channel.Close()
+
+	confirms, returns, err := confirmMode(channel)
+	if err != nil {
+		return 0, err
+	}
+	return redrive(channel, confirms, returns, queueName, filter)
+}
+
+type deadLetterChannel interface {
+	amqpPublisher
+	Get(queue string, autoAck bool) (amqp.Delivery, bool, error)
+}
+
+// redrive 逐条取出死信队列中的消息重新发送，broker 确认并且没有退回时才 ack
+func redrive(channel deadLetterChannel, confirms <-chan amqp.Confirmation, returns <-chan amqp.Return,
+	queueName string, filter func(dl *DeadLetter) bool) (int, error) {
+	dlq := DeadLetterQueueName(queueName)
+	n := 0
+	for {
+		d, ok, err := channel.Get(dlq, false)
+		if err != nil {
+			return n, err
+		}
+		if !ok {
+			return n, nil
+		}
+		dl := newDeadLetter(&d, queueName)
+		if filter != nil && !filter(dl) {
+			continue
+		}
+		if dl.OriginalExchange == "" && dl.OriginalRoutingKey == "" {
+			// 无法确定原来的路由，发回原来的队列
+			dl.OriginalRoutingKey = dl.Queue
+		}
+		err = publishMandatory(channel, confirms, returns, dl.OriginalExchange, dl.OriginalRoutingKey, redrivePublishing(&d))
+		if err != nil {
+			return n, fmt.Errorf("redrive: %w", err)
+		}
+		if err = d.Ack(false); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// redrivePublishing 去掉死信和重试相关的消息头，其他属性保持不变
+func redrivePublishing(d *amqp.Delivery) amqp.Publishing {
+	headers := make(amqp.Table, len(d.Headers))
+	for k, v := range d.Headers {
+		switch k {
+		case deathHeader, "x-first-death-reason", "x-first-death-queue", "x-first-death-exchange",
+			retryAttemptHeader, lastErrorHeader, originalExchangeHeader, originalRoutingKeyHeader:
+			continue
+		}
+		headers[k] = v
+	}
+	return amqp.Publishing{
+		Headers:         headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    d.DeliveryMode,
+		Priority:        d.Priority,
+		CorrelationId:   d.CorrelationId,
+		ReplyTo:         d.ReplyTo,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		UserId:          d.UserId,
+		AppId:           d.AppId,
+		Body:            d.Body,
+	}
+}
@@ -0,0 +1,172 @@
+package rbmq
+
+import (
+	"errors"
+	"github.com/streadway/amqp"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAcknowledger 记录 ack、nack 的消息，可以并发使用
+type fakeAcknowledger struct {
+	mu     sync.Mutex
+	acked  []uint64
+	nacked []uint64
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.acked = append(a.acked, tag)
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.nacked = append(a.nacked, tag)
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func TestRedrive(t *testing.T) {
+	tests := []struct {
+		name       string
+		unroutable bool
+		filter     func(dl *DeadLetter) bool
+		wantN      int
+		wantAcked  []uint64
+		wantErr    error
+	}{
+		{name: "all", wantN: 2, wantAcked: []uint64{1, 2}},
+		{name: "filtered", filter: func(dl *DeadLetter) bool { return dl.MessageId == "2" }, wantN: 1, wantAcked: []uint64{2}},
+		{name: "unroutable stays in dlq", unroutable: true, wantErr: MessageUnroutable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ack := &fakeAcknowledger{}
+			c := newFakeChannel()
+			c.unroutable = tt.unroutable
+			for i, id := range []string{"1", "2"} {
+				c.deliveries = append(c.deliveries, amqp.Delivery{
+					Acknowledger: ack,
+					DeliveryTag:  uint64(i + 1),
+					MessageId:    id,
+					Headers: amqp.Table{
+						retryAttemptHeader:       int64(3),
+						originalExchangeHeader:   "orders",
+						originalRoutingKeyHeader: "order.created",
+					},
+					Body: []byte(id),
+				})
+			}
+
+			n, err := redrive(c, c.confirms, c.returns, "q", tt.filter)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("redrive = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("redrive: %v", err)
+			}
+			if n != tt.wantN {
+				t.Errorf("n = %d, want %d", n, tt.wantN)
+			}
+			if len(ack.acked) != len(tt.wantAcked) {
+				t.Fatalf("acked = %v, want %v", ack.acked, tt.wantAcked)
+			}
+			for i, tag := range tt.wantAcked {
+				if ack.acked[i] != tag {
+					t.Errorf("acked = %v, want %v", ack.acked, tt.wantAcked)
+				}
+			}
+			for _, msg := range c.published {
+				if _, ok := msg.Headers[retryAttemptHeader]; ok {
+					t.Errorf("retry header is not removed: %v", msg.Headers)
+				}
+			}
+		})
+	}
+}
+
+func TestNewDeadLetter(t *testing.T) {
+	deathTime := time.Unix(1700000000, 0)
+	retried := func(attempt int64, lastError string, deaths ...interface{}) amqp.Table {
+		h := amqp.Table{
+			retryAttemptHeader:       attempt,
+			lastErrorHeader:          lastError,
+			originalExchangeHeader:   "orders",
+			originalRoutingKeyHeader: "order.created",
+		}
+		if len(deaths) > 0 {
+			h[deathHeader] = deaths
+		}
+		return h
+	}
+	death := func(reason, queue, exchange, routingKey string, count int64) amqp.Table {
+		return amqp.Table{
+			"reason":       reason,
+			"queue":        queue,
+			"exchange":     exchange,
+			"routing-keys": []interface{}{routingKey},
+			"count":        count,
+			"time":         deathTime,
+		}
+	}
+	retryExpired := death("expired", "q.retry.1000", "", "q.retry.1000", 2)
+
+	tests := []struct {
+		name    string
+		headers amqp.Table
+		want    DeadLetter
+	}{
+		{
+			name:    "rejected by consumer",
+			headers: amqp.Table{deathHeader: []interface{}{death("rejected", "q", "orders", "order.created", 2)}},
+			want:    DeadLetter{Reason: "rejected", Queue: "q", OriginalExchange: "orders", OriginalRoutingKey: "order.created", Count: 2, Time: deathTime},
+		},
+		{
+			name:    "expired in queue",
+			headers: amqp.Table{deathHeader: []interface{}{death("expired", "q", "", "q", 1)}},
+			want:    DeadLetter{Reason: "expired", Queue: "q", OriginalRoutingKey: "q", Count: 1, Time: deathTime},
+		},
+		{
+			name:    "retry policy without retries",
+			headers: retried(1, "boom"),
+			want:    DeadLetter{Reason: DeadLetterReasonFailed, Queue: "q", OriginalExchange: "orders", OriginalRoutingKey: "order.created", Count: 1, Attempts: 1, LastError: "boom"},
+		},
+		{
+			name:    "retry policy exhausted",
+			headers: retried(3, "boom", retryExpired),
+			want:    DeadLetter{Reason: DeadLetterReasonFailed, Queue: "q", OriginalExchange: "orders", OriginalRoutingKey: "order.created", Count: 1, Attempts: 3, LastError: "boom"},
+		},
+		{
+			name:    "rejected by broker after retry",
+			headers: retried(1, "stale", death("rejected", "q", "", "q", 1), retryExpired),
+			want:    DeadLetter{Reason: "rejected", Queue: "q", OriginalExchange: "orders", OriginalRoutingKey: "order.created", Count: 1, Time: deathTime},
+		},
+		{
+			name:    "maxlen after retry",
+			headers: retried(1, "stale", death("maxlen", "q", "", "q", 1), retryExpired),
+			want:    DeadLetter{Reason: "maxlen", Queue: "q", OriginalExchange: "orders", OriginalRoutingKey: "order.created", Count: 1, Time: deathTime},
+		},
+		{
+			name:    "expired after retry",
+			headers: retried(2, "stale", death("expired", "q", "", "q", 1), retryExpired),
+			want:    DeadLetter{Reason: "expired", Queue: "q", OriginalExchange: "orders", OriginalRoutingKey: "order.created", Count: 1, Time: deathTime},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newDeadLetter(&amqp.Delivery{Headers: tt.headers}, "q")
+			got.Message = nil
+			if *got != tt.want {
+				t.Errorf("newDeadLetter = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
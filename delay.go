@@ -0,0 +1,341 @@
+package rbmq
+
+import (
+	"fmt"
+	"github.com/streadway/amqp"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+6 Delayed 延时模式，消息在指定的延时之后才能被消费者消费
+
+	应用场景: 订单超时取消，定时提醒
+
+延时的实现方式有两种，通过 DelayMode 选择：
+(1) DelayModeTTL：TTL + 死信。每种延时时长对应一个 fanout 交换机和一个设置了 x-message-ttl 的延时队列，延时队列没有消费者，
+消息过期后通过 x-dead-letter-exchange 投递回目标交换机，并保留原来的路由 key。因为每个延时队列的 TTL 是固定的，
+不会出现队头消息阻塞后面消息过期的问题，但延时时长种类很多时会创建很多队列，适合固定的几种延时。
+该模式必须通过 WithDelayBuckets 指定允许的延时档位，发送时延时向上取整到最近的档位（消息不会早于指定的延时投递），
+超过最大档位的延时返回 DelayOutOfRange，保证 broker 上的延时队列数量有上限。
+(2) DelayModePlugin：依赖 rabbitmq_delayed_message_exchange 插件，交换机类型为 x-delayed-message，通过 x-delay 头指定延时毫秒数，
+延时可以是任意值，但需要 broker 安装插件。
+
+PublishAt 指定的投递时刻每次都不同，只支持 DelayModePlugin，DelayModeTTL 下返回 PublishAtRequiresPlugin。
+*/
+
+type DelayMode int
+
+const (
+	DelayModeTTL    DelayMode = iota // TTL + 死信队列
+	DelayModePlugin                  // x-delayed-message 插件
+)
+
+const (
+	delayedExchangeKind = "x-delayed-message"
+	delayHeader         = "x-delay"
+)
+
+type DelayedPublisher struct {
+	*BasePublisher
+	exchangeName string
+	kind         string
+	durable      bool
+	autoDelete   bool
+	mode         DelayMode
+	delayQueues  sync.Map // 已经申请过的延时队列(DelayModeTTL)，key 为延时毫秒数
+}
+
+// NewDelayedPublisher 创建延时模式下的 publisher
+// conn：rabbit mq 连接
+// exchangeName：目标交换机，不能为空
+// kind：目标交换机类型，direct、topic、fanout，为空时默认为 direct
+// durable：持久化
+// autoDelete：自动删除
+// mode：延时的实现方式
+// opts：可选配置
+func NewDelayedPublisher(conn *RMQConn, exchangeName, kind string, durable, autoDelete bool, mode DelayMode, opts ...PublisherOption) (*DelayedPublisher, error) {
+	if conn == nil {
+		return nil, ConnIsNil
+	}
+	if exchangeName == "" {
+		return nil, ExchangeNameIsEmpty
+	}
+	if kind == "" {
+		kind = amqp.ExchangeDirect
+	}
+	r := &DelayedPublisher{
+		BasePublisher: NewBasePublisher(conn, opts...),
+		exchangeName:  exchangeName,
+		kind:          kind,
+		durable:       durable,
+		autoDelete:    autoDelete,
+		mode:          mode,
+	}
+	if mode == DelayModeTTL && len(r.opts.delayBuckets) == 0 {
+		return nil, DelayBucketsNotConfigured
+	}
+	channel, err := r.mqConn.GetConn().Channel()
+	if err != nil {
+		return nil, err
+	}
+	defer channel.Close()
+
+	// 尝试创建目标交换机，不存在创建
+	err = declareDelayedExchange(channel, exchangeName, kind, durable, autoDelete, mode, r.opts.exchange)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// PublishDelayed 发送延时消息
+// message：消息内容
+// routingKey：路由 key，fanout 类型交换机可为空
+// delay：延时时长，小于等于 0 时立即投递
+// opts：单条消息的可选配置
+func (r *DelayedPublisher) PublishDelayed(message []byte, routingKey string, delay time.Duration, opts ...PublishOption) (err error) {
+	msg := amqp.Publishing{
+		DeliveryMode: amqp.Persistent, // 持久化
+		ContentType:  "text/plain",
+		Body:         message,
+		Timestamp:    time.Now(),
+	}
+	delayMs := delay.Milliseconds()
+	if delayMs <= 0 {
+		// 不需要延时，直接投递到目标交换机
+		return r.publish(r.exchangeName, routingKey, msg, opts...)
+	}
+
+	switch r.mode {
+	case DelayModePlugin:
+		msg.Headers = amqp.Table{delayHeader: delayMs}
+		return r.publish(r.exchangeName, routingKey, msg, opts...)
+	case DelayModeTTL:
+		bucket, err := r.bucket(delay)
+		if err != nil {
+			return err
+		}
+		delayExchange, err := r.declareDelayQueue(bucket.Milliseconds())
+		if err != nil {
+			return err
+		}
+		// 发送到延时交换机，路由 key 保持不变，过期后死信回目标交换机时沿用该路由 key
+		return r.publish(delayExchange, routingKey, msg, opts...)
+	default:
+		return DelayModeUnknown
+	}
+}
+
+// PublishAt 发送定时消息，消息在 at 时刻之后才能被消费，只支持 DelayModePlugin
+// message：消息内容
+// routingKey：路由 key，fanout 类型交换机可为空
+// at：投递时间，早于当前时间时立即投递
+// opts：单条消息的可选配置
+func (r *DelayedPublisher) PublishAt(message []byte, routingKey string, at time.Time, opts ...PublishOption) error {
+	if r.mode != DelayModePlugin {
+		return PublishAtRequiresPlugin
+	}
+	return r.PublishDelayed(message, routingKey, time.Until(at), opts...)
+}
+
+// bucket DelayModeTTL 下返回不小于 delay 的最小延时档位
+func (r *DelayedPublisher) bucket(delay time.Duration) (time.Duration, error) {
+	for _, b := range r.opts.delayBuckets {
+		if b >= delay {
+			return b, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: delay %s, max %s", DelayOutOfRange, delay, r.opts.delayBuckets[len(r.opts.delayBuckets)-1])
+}
+
+// WithDelayBuckets 指定 DelayModeTTL 允许的延时档位，每个档位对应一个延时队列，毫秒精度，DelayModePlugin 下忽略
+func WithDelayBuckets(delays ...time.Duration) PublisherOption {
+	buckets := make([]time.Duration, 0, len(delays))
+	for _, d := range delays {
+		if d = d.Truncate(time.Millisecond); d > 0 {
+			buckets = append(buckets, d)
+		}
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+	return publisherOptionFunc(func(o *publisherOptions) {
+		o.delayBuckets = buckets
+	})
+}
+
+// PublishMessage 发送自定义属性的消息，不延时，直接投递到目标交换机
+func (r *DelayedPublisher) PublishMessage(routingKey string, msg amqp.Publishing) error {
+	return r.publish(r.exchangeName, routingKey, msg)
+}
+
+// declareDelayQueue 申请某个延时时长对应的延时交换机和延时队列，返回延时交换机名
+func (r *DelayedPublisher) declareDelayQueue(delayMs int64) (string, error) {
+	name := fmt.Sprintf("%s.delay.%d", r.exchangeName, delayMs)
+	if _, ok := r.delayQueues.Load(delayMs); ok {
+		return name, nil
+	}
+
+	channel, err := r.mqConn.GetConn().Channel()
+	if err != nil {
+		return "", err
+	}
+	defer channel.Close()
+
+	// 1、申请延时交换机，fanout 类型，只负责把消息转给延时队列
+	err = channel.ExchangeDeclare(
+		name,
+		amqp.ExchangeFanout,
+		r.durable,
+		r.autoDelete,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	// 2、申请延时队列，没有消费者，消息过期后死信到目标交换机
+	_, err = channel.QueueDeclare(
+		name,
+		r.durable,
+		r.autoDelete,
+		false,
+		false,
+		amqp.Table{
+			"x-message-ttl":          delayMs,
+			"x-dead-letter-exchange": r.exchangeName,
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	// 3、绑定延时队列到延时交换机
+	err = channel.QueueBind(name, "", name, false, nil)
+	if err != nil {
+		return "", err
+	}
+	r.delayQueues.Store(delayMs, struct{}{})
+	return name, nil
+}
+
+type DelayedConsumer struct {
+	*BaseConsumer
+}
+
+// NewDelayedConsumer 创建延时模式下的 consumer
+// conn：rabbit mq 连接
+// exchangeName：目标交换机，不能为空，需要跟 publisher 保持一致
+// kind：目标交换机类型，需要跟 publisher 保持一致，为空时默认为 direct
+// queueName：可为空，为空则自动生成，队列名为空时，队列强制为非持久化和自动删除
+// routingKey：绑定路由，fanout 类型交换机可为空
+// durable：持久化
+// autoDelete：自动删除
+// mode：延时的实现方式，需要跟 publisher 保持一致
+// opts：可选配置
+func NewDelayedConsumer(conn *RMQConn, exchangeName, kind, queueName, routingKey string, durable, autoDelete bool, mode DelayMode, opts ...ConsumerOption) (IConsumer, error) {
+	if conn == nil {
+		return nil, ConnIsNil
+	}
+	if exchangeName == "" {
+		return nil, ExchangeNameIsEmpty
+	}
+	if kind == "" {
+		kind = amqp.ExchangeDirect
+	}
+	if len(routingKey) == 0 && kind != amqp.ExchangeFanout {
+		return nil, RoutingKeyIsRequired
+	}
+
+	channel, err := conn.GetConn().Channel()
+	if err != nil {
+		return nil, err
+	}
+	defer channel.Close()
+
+	// 1、尝试创建目标交换机，不存在创建
+	o := newConsumerOptions(opts)
+	err = declareDelayedExchange(channel, exchangeName, kind, durable, autoDelete, mode, o.exchange)
+	if err != nil {
+		return nil, err
+	}
+
+	// 队列名为空时，队列强制为非持久化和自动删除
+	if queueName == "" {
+		durable = false
+		autoDelete = true
+	}
+
+	queueArgs, err := o.queue.arguments(durable, autoDelete)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = o.checkRetry(queueName); err != nil {
+		return nil, err
+	}
+
+	if o.deadLetter {
+		queueArgs, err = deadLetterArguments(channel, queueName, queueArgs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	//2、 试探性创建队列
+	q, err := channel.QueueDeclare(
+		queueName,
+		durable,
+		autoDelete,
+		false,
+		false,
+		queueArgs,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	//3、绑定队列到 exchange中
+	err = channel.QueueBind(
+		q.Name,
+		routingKey,
+		exchangeName,
+		false,
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &DelayedConsumer{}
+	c.BaseConsumer = NewBaseConsumer(conn, DefaultPrefetchCount, q.Name, c, opts...)
+	return c, nil
+}
+
+// declareDelayedExchange 申请延时模式下的目标交换机
+// DelayModePlugin 模式下交换机类型为 x-delayed-message，真实的路由类型通过 x-delayed-type 参数指定
+func declareDelayedExchange(channel *amqp.Channel, exchangeName, kind string, durable, autoDelete bool, mode DelayMode, o *ExchangeOptions) error {
+	kind, internal, args := o.declareArgs(kind)
+	switch mode {
+	case DelayModeTTL:
+	case DelayModePlugin:
+		if args == nil {
+			args = amqp.Table{}
+		}
+		args["x-delayed-type"] = kind
+		kind = delayedExchangeKind
+	default:
+		return DelayModeUnknown
+	}
+	return channel.ExchangeDeclare(
+		exchangeName,
+		kind,
+		durable,
+		autoDelete,
+		internal,
+		false,
+		args,
+	)
+}
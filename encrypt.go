@@ -0,0 +1,220 @@
+package rbmq
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"github.com/streadway/amqp"
+	"sync"
+)
+
+/*
+关于消息加密
+采用信封加密：每条消息随机生成一个数据密钥（DEK），用 DEK 以 AES-GCM 加密消息体；再用 KeyProvider 提供的主密钥（KEK）
+以 AES-GCM 加密 DEK，把加密后的 DEK 和主密钥 ID 放在消息头中。broker 上只能看到密文，主密钥也不会随消息传输。
+
+密钥轮换：KeyProvider 可以同时持有多个主密钥，publisher 总是用当前密钥加密，consumer 根据消息头中的密钥 ID 查找对应的密钥解密。
+轮换时先把新密钥加入所有 consumer，再切换 publisher 的当前密钥，等旧密钥加密的消息消费完后再移除旧密钥。
+
+加密在压缩之后进行（密文无法压缩），consumer 先解密再解压。
+*/
+
+const (
+	encryptionHeader      = "x-encryption"        // 加密算法
+	encryptionKeyIdHeader = "x-encryption-key-id" // 主密钥 ID
+	encryptionDEKHeader   = "x-encryption-dek"    // 主密钥加密后的数据密钥
+
+	encryptionAlgorithm = "aes-gcm"
+	dataKeySize         = 32 // AES-256
+)
+
+// KeyProvider 提供加解密使用的主密钥，密钥长度必须为 16、24 或 32 字节
+type KeyProvider interface {
+	CurrentKey() (keyId string, key []byte, err error) // 加密使用的当前密钥
+	Key(keyId string) ([]byte, error)                  // 解密时根据消息中的密钥 ID 查找密钥
+}
+
+// KeyRing 基于内存的 KeyProvider，支持多个密钥同时有效，可以并发使用
+type KeyRing struct {
+	mu      sync.RWMutex
+	current string
+	keys    map[string][]byte
+}
+
+// NewKeyRing 创建 KeyRing
+// currentId：当前密钥 ID，必须在 keys 中
+// keys：密钥 ID -> 密钥
+func NewKeyRing(currentId string, keys map[string][]byte) (*KeyRing, error) {
+	k := &KeyRing{
+		keys: make(map[string][]byte, len(keys)),
+	}
+	for id, key := range keys {
+		if err := k.AddKey(id, key); err != nil {
+			return nil, err
+		}
+	}
+	if err := k.SetCurrent(currentId); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// AddKey 添加密钥，已存在的同 ID 密钥会被覆盖
+func (k *KeyRing) AddKey(keyId string, key []byte) error {
+	if _, err := aes.NewCipher(key); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[keyId] = append([]byte(nil), key...)
+	return nil
+}
+
+// SetCurrent 切换加密使用的当前密钥
+func (k *KeyRing) SetCurrent(keyId string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[keyId]; !ok {
+		return fmt.Errorf("%w: %s", EncryptionKeyNotFound, keyId)
+	}
+	k.current = keyId
+	return nil
+}
+
+// RemoveKey 移除密钥，不能移除当前密钥
+func (k *KeyRing) RemoveKey(keyId string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if keyId != k.current {
+		delete(k.keys, keyId)
+	}
+}
+
+func (k *KeyRing) CurrentKey() (string, []byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current, k.keys[k.current], nil
+}
+
+func (k *KeyRing) Key(keyId string) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[keyId]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", EncryptionKeyNotFound, keyId)
+	}
+	return key, nil
+}
+
+// WithEncryption 给 publisher 开启加密
+func WithEncryption(kp KeyProvider) PublisherOption {
+	return publisherOptionFunc(func(o *publisherOptions) {
+		o.keyProvider = kp
+	})
+}
+
+// WithDecryption 给 consumer 配置解密使用的密钥，没有加密的消息原样交给处理函数
+func WithDecryption(kp KeyProvider) ConsumerOption {
+	return consumerOptionFunc(func(o *consumerOptions) {
+		o.keyProvider = kp
+	})
+}
+
+// encryptPublishing 加密消息体，并把加密信息写入消息头
+func encryptPublishing(msg *amqp.Publishing, kp KeyProvider) error {
+	keyId, kek, err := kp.CurrentKey()
+	if err != nil {
+		return err
+	}
+	dek := make([]byte, dataKeySize)
+	if _, err = rand.Read(dek); err != nil {
+		return err
+	}
+	body, err := gcmSeal(dek, msg.Body, nil)
+	if err != nil {
+		return err
+	}
+	// 密钥 ID 作为附加数据，防止加密的数据密钥被挪用到其他密钥 ID 下
+	wrappedDEK, err := gcmSeal(kek, dek, []byte(keyId))
+	if err != nil {
+		return err
+	}
+
+	headers := make(amqp.Table, len(msg.Headers)+3)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[encryptionHeader] = encryptionAlgorithm
+	headers[encryptionKeyIdHeader] = keyId
+	headers[encryptionDEKHeader] = wrappedDEK
+	msg.Headers = headers
+	msg.Body = body
+	return nil
+}
+
+// decryptDelivery 解密消息体，没有加密的消息原样返回
+func decryptDelivery(d *amqp.Delivery, kp KeyProvider) error {
+	algorithm, ok := d.Headers[encryptionHeader]
+	if !ok {
+		return nil
+	}
+	if kp == nil {
+		return EncryptionKeyNotConfigured
+	}
+	if algorithm != encryptionAlgorithm {
+		return fmt.Errorf("decrypt: unsupported algorithm %v", algorithm)
+	}
+	keyId, _ := d.Headers[encryptionKeyIdHeader].(string)
+	wrappedDEK, _ := d.Headers[encryptionDEKHeader].([]byte)
+	kek, err := kp.Key(keyId)
+	if err != nil {
+		return err
+	}
+	dek, err := gcmOpen(kek, wrappedDEK, []byte(keyId))
+	if err != nil {
+		return fmt.Errorf("decrypt data key: %w", err)
+	}
+	body, err := gcmOpen(dek, d.Body, nil)
+	if err != nil {
+		return fmt.Errorf("decrypt body: %w", err)
+	}
+	d.Body = body
+	delete(d.Headers, encryptionHeader)
+	delete(d.Headers, encryptionKeyIdHeader)
+	delete(d.Headers, encryptionDEKHeader)
+	return nil
+}
+
+// gcmSeal AES-GCM 加密，随机 nonce 放在密文前面
+func gcmSeal(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func gcmOpen(key, ciphertext, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,184 @@
+package rbmq
+
+import (
+	"bytes"
+	"errors"
+	"github.com/streadway/amqp"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+// encryptToDelivery 加密消息，并转换成 consumer 收到的 delivery
+func encryptToDelivery(t *testing.T, msg amqp.Publishing, kp KeyProvider) *amqp.Delivery {
+	t.Helper()
+	if err := encryptPublishing(&msg, kp); err != nil {
+		t.Fatalf("encryptPublishing: %v", err)
+	}
+	headers := make(amqp.Table, len(msg.Headers))
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	return &amqp.Delivery{Headers: headers, Body: append([]byte(nil), msg.Body...)}
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     []byte
+		body    []byte
+		headers amqp.Table
+	}{
+		{name: "empty body", key: testKey(1), body: []byte{}},
+		{name: "aes-128", key: testKey(1)[:16], body: []byte("hello")},
+		{name: "aes-192", key: testKey(1)[:24], body: []byte("hello")},
+		{name: "large body", key: testKey(1), body: bytes.Repeat([]byte("0123456789"), 10000)},
+		{name: "keeps other headers", key: testKey(1), body: []byte("hello"), headers: amqp.Table{"trace-id": "abc"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ring, err := NewKeyRing("k1", map[string][]byte{"k1": tt.key})
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := encryptToDelivery(t, amqp.Publishing{Headers: tt.headers, Body: tt.body}, ring)
+			if len(tt.body) > 0 && bytes.Contains(d.Body, tt.body) {
+				t.Error("body is not encrypted")
+			}
+			if d.Headers[encryptionKeyIdHeader] != "k1" {
+				t.Errorf("key id = %v, want k1", d.Headers[encryptionKeyIdHeader])
+			}
+			if err = decryptDelivery(d, ring); err != nil {
+				t.Fatalf("decryptDelivery: %v", err)
+			}
+			if !bytes.Equal(d.Body, tt.body) {
+				t.Errorf("body = %q, want %q", d.Body, tt.body)
+			}
+			for _, h := range []string{encryptionHeader, encryptionKeyIdHeader, encryptionDEKHeader} {
+				if _, ok := d.Headers[h]; ok {
+					t.Errorf("header %s not removed", h)
+				}
+			}
+			for k, v := range tt.headers {
+				if d.Headers[k] != v {
+					t.Errorf("header %s = %v, want %v", k, d.Headers[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestEncryptKeyRotation(t *testing.T) {
+	ring, err := NewKeyRing("k1", map[string][]byte{"k1": testKey(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := encryptToDelivery(t, amqp.Publishing{Body: []byte("old")}, ring)
+	pending := encryptToDelivery(t, amqp.Publishing{Body: []byte("pending")}, ring)
+
+	if err = ring.AddKey("k2", testKey(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err = ring.SetCurrent("k2"); err != nil {
+		t.Fatal(err)
+	}
+	cur := encryptToDelivery(t, amqp.Publishing{Body: []byte("new")}, ring)
+	if cur.Headers[encryptionKeyIdHeader] != "k2" {
+		t.Errorf("key id = %v, want k2", cur.Headers[encryptionKeyIdHeader])
+	}
+
+	// 切换当前密钥后旧密钥加密的消息依然可以解密
+	for _, d := range []*amqp.Delivery{old, cur} {
+		want := "old"
+		if d == cur {
+			want = "new"
+		}
+		if err = decryptDelivery(d, ring); err != nil {
+			t.Fatalf("decrypt %s: %v", want, err)
+		}
+		if string(d.Body) != want {
+			t.Errorf("body = %q, want %q", d.Body, want)
+		}
+	}
+
+	// 移除旧密钥后旧消息无法解密，当前密钥不能被移除
+	ring.RemoveKey("k1")
+	ring.RemoveKey("k2")
+	if err = decryptDelivery(pending, ring); !errors.Is(err, EncryptionKeyNotFound) {
+		t.Errorf("decrypt after RemoveKey = %v, want EncryptionKeyNotFound", err)
+	}
+	if _, err = ring.Key("k2"); err != nil {
+		t.Errorf("current key removed: %v", err)
+	}
+	if err = ring.SetCurrent("k1"); !errors.Is(err, EncryptionKeyNotFound) {
+		t.Errorf("SetCurrent(k1) = %v, want EncryptionKeyNotFound", err)
+	}
+}
+
+func TestDecryptDeliveryErrors(t *testing.T) {
+	ring, err := NewKeyRing("k1", map[string][]byte{"k1": testKey(1), "k2": testKey(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewKeyRing("k2", map[string][]byte{"k2": testKey(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		kp      KeyProvider
+		tamper  func(d *amqp.Delivery)
+		wantErr error // 为空时只要求返回错误
+	}{
+		{name: "unknown key id", kp: other, wantErr: EncryptionKeyNotFound},
+		{name: "no key provider", wantErr: EncryptionKeyNotConfigured},
+		{name: "unsupported algorithm", kp: ring, tamper: func(d *amqp.Delivery) {
+			d.Headers[encryptionHeader] = "rot13"
+		}},
+		{name: "tampered dek", kp: ring, tamper: func(d *amqp.Delivery) {
+			dek := append([]byte(nil), d.Headers[encryptionDEKHeader].([]byte)...)
+			dek[len(dek)-1] ^= 1
+			d.Headers[encryptionDEKHeader] = dek
+		}},
+		{name: "missing dek", kp: ring, tamper: func(d *amqp.Delivery) {
+			delete(d.Headers, encryptionDEKHeader)
+		}},
+		{name: "dek moved to another key id", kp: ring, tamper: func(d *amqp.Delivery) {
+			d.Headers[encryptionKeyIdHeader] = "k2"
+		}},
+		{name: "tampered body", kp: ring, tamper: func(d *amqp.Delivery) {
+			d.Body[len(d.Body)-1] ^= 1
+		}},
+		{name: "truncated body", kp: ring, tamper: func(d *amqp.Delivery) {
+			d.Body = d.Body[:4]
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := encryptToDelivery(t, amqp.Publishing{Body: []byte("secret")}, ring)
+			if tt.tamper != nil {
+				tt.tamper(d)
+			}
+			err := decryptDelivery(d, tt.kp)
+			if err == nil {
+				t.Fatal("decryptDelivery succeeded, want error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("decryptDelivery = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecryptDeliveryPlaintext(t *testing.T) {
+	d := &amqp.Delivery{Headers: amqp.Table{"trace-id": "abc"}, Body: []byte("plain")}
+	if err := decryptDelivery(d, nil); err != nil {
+		t.Fatalf("decryptDelivery: %v", err)
+	}
+	if string(d.Body) != "plain" {
+		t.Errorf("body = %q, want plain", d.Body)
+	}
+}
@@ -7,4 +7,32 @@ var (
 	ExchangeNameIsEmpty  = errors.New("exchange name is empty")
 	QueueNameIsEmpty     = errors.New("queue name is empty")
 	RoutingKeyIsRequired = errors.New("routingKey is required")
+	DelayModeUnknown     = errors.New("unknown delay mode")
+	RPCReplyModeUnknown  = errors.New("unknown rpc reply mode")
+	RPCNoRoute           = errors.New("rpc request is unroutable")
+	RPCChannelClosed     = errors.New("rpc channel closed before reply")
+	RateLimitExceeded    = errors.New("publish rate limit exceeded")
+
+	EncryptionKeyNotFound      = errors.New("encryption key not found")
+	EncryptionKeyNotConfigured = errors.New("message is encrypted but no key provider is configured")
+	SignatureMissing           = errors.New("message signature is missing")
+	SignatureInvalid           = errors.New("message signature is invalid")
+
+	QueueOptionsInvalid          = errors.New("invalid queue options")
+	PriorityOutOfRange           = errors.New("message priority exceeds queue max priority")
+	StreamConsumerNameIsEmpty    = errors.New("stream consumer name is empty")
+	BlobNotFound                 = errors.New("blob not found")
+	ClaimCheckStoreNotConfigured = errors.New("message is claim-checked but no blob store is configured")
+	ShardCountInvalid            = errors.New("shard count must be positive")
+	ShardModeUnknown             = errors.New("unknown shard mode")
+	ConsumerIsRunning            = errors.New("consumer is already running")
+	ConsumerNeverStarted         = errors.New("consumer has never been started")
+	BatchSizeInvalid             = errors.New("batch size must be positive")
+	HandlerTimeout               = errors.New("handler timed out")
+
+	DelayBucketsNotConfigured = errors.New("ttl delay mode requires delay buckets")
+	DelayOutOfRange           = errors.New("delay exceeds the largest delay bucket")
+	PublishAtRequiresPlugin   = errors.New("publish at requires plugin delay mode")
+
+	MessageUnroutable = errors.New("message is unroutable")
 )
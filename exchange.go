@@ -0,0 +1,62 @@
+package rbmq
+
+import (
+	"github.com/streadway/amqp"
+)
+
+/*
+关于交换机参数
+通过 WithExchangeOptions 在创建 publisher 和 consumer 时指定交换机参数，publisher 与 consumer 申请同一个交换机时
+需要使用相同的参数，否则 rabbitmq 会返回 PRECONDITION_FAILED。
+
+(1) alternate-exchange 备用交换机：交换机无法路由的消息转发到备用交换机，而不是直接丢弃，备用交换机一般为 fanout 类型
+(2) internal 内部交换机：不能被 client 直接推送消息，只能通过 exchange 之间的绑定接收消息，用于多级路由
+(3) 自定义类型：例如插件提供的 x-consistent-hash、x-modulus-hash 等，替换各模式默认的交换机类型
+*/
+
+// ExchangeOptions 交换机参数，零值表示不设置
+type ExchangeOptions struct {
+	Kind              string     // 交换机类型，为空时使用各模式默认的类型
+	Internal          bool       // 内部交换机
+	AlternateExchange string     // 备用交换机，为空时不设置
+	Args              amqp.Table // 其他参数，与上面的字段冲突时以上面的字段为准
+}
+
+// WithExchangeOptions 指定构造函数中申请的交换机的参数
+func WithExchangeOptions(e ExchangeOptions) Option {
+	return exchangeOption{e: e}
+}
+
+type exchangeOption struct {
+	e ExchangeOptions
+}
+
+func (o exchangeOption) applyPublisher(p *publisherOptions) {
+	p.exchange = &o.e
+}
+
+func (o exchangeOption) applyConsumer(c *consumerOptions) {
+	c.exchange = &o.e
+}
+
+// declareArgs 返回申请交换机时的类型、internal 和参数，o 为空时使用默认类型
+// kind：各模式默认的交换机类型
+func (o *ExchangeOptions) declareArgs(kind string) (string, bool, amqp.Table) {
+	if o == nil {
+		return kind, false, nil
+	}
+	if o.Kind != "" {
+		kind = o.Kind
+	}
+	var args amqp.Table
+	if len(o.Args) > 0 || o.AlternateExchange != "" {
+		args = make(amqp.Table, len(o.Args)+1)
+		for k, v := range o.Args {
+			args[k] = v
+		}
+		if o.AlternateExchange != "" {
+			args["alternate-exchange"] = o.AlternateExchange
+		}
+	}
+	return kind, o.Internal, args
+}
@@ -3,3 +3,11 @@ module github.com/gzltommy/rbmq
 go 1.18
 
 require github.com/streadway/amqp v1.0.0
+
+require (
+	github.com/klauspost/compress v1.17.2
+	github.com/vmihailenco/msgpack/v5 v5.3.5
+	google.golang.org/protobuf v1.34.1
+)
+
+require github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
@@ -0,0 +1,68 @@
+package rbmq
+
+import (
+	"context"
+	"github.com/streadway/amqp"
+	"time"
+)
+
+// Message 消费到的消息，包含还原后的消息体以及投递的元数据
+type Message struct {
+	Body []byte // 消息体，已经解压、解密、验签、取回
+
+	Headers         amqp.Table // 消息头
+	ContentType     string
+	ContentEncoding string
+	DeliveryMode    uint8 // 持久化（2）或非持久化（1）
+	Priority        uint8 // 优先级
+	CorrelationId   string
+	ReplyTo         string
+	Expiration      string
+	MessageId       string
+	Timestamp       time.Time
+	Type            string
+	UserId          string
+	AppId           string
+
+	Exchange    string // 消息发送到的交换机
+	RoutingKey  string // 消息发送时的路由 key
+	ConsumerTag string
+	DeliveryTag uint64
+	Redelivered bool // 消息之前投递过但没有 ack（处理失败重新入队或者连接断开）
+}
+
+// MessageHandler 可以拿到消息元数据的处理函数
+// ctx 在 Stop 超时时取消，处理耗时较长时应该关注 ctx
+type MessageHandler func(ctx context.Context, msg *Message) error
+
+// newMessage 从 delivery 创建 Message，d 需要已经还原消息体
+func newMessage(d *amqp.Delivery) *Message {
+	return &Message{
+		Body:            d.Body,
+		Headers:         d.Headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    d.DeliveryMode,
+		Priority:        d.Priority,
+		CorrelationId:   d.CorrelationId,
+		ReplyTo:         d.ReplyTo,
+		Expiration:      d.Expiration,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		UserId:          d.UserId,
+		AppId:           d.AppId,
+		Exchange:        d.Exchange,
+		RoutingKey:      d.RoutingKey,
+		ConsumerTag:     d.ConsumerTag,
+		DeliveryTag:     d.DeliveryTag,
+		Redelivered:     d.Redelivered,
+	}
+}
+
+// ConsumeHandlerAdapter 把只处理消息体的 ConsumeHandler 转换为 MessageHandler
+func ConsumeHandlerAdapter(handler ConsumeHandler) MessageHandler {
+	return func(ctx context.Context, msg *Message) error {
+		return handler(msg.Body)
+	}
+}
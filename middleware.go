@@ -0,0 +1,156 @@
+package rbmq
+
+import (
+	"context"
+	"github.com/streadway/amqp"
+	"log"
+	"runtime/debug"
+	"time"
+)
+
+/*
+关于中间件
+Middleware 包装 consumer 的处理函数，通过 WithMiddleware 指定，对该 consumer 的 Consume、ConsumeMessage、Start
+以及基于它们的 ConsumeTyped、RPCServer 生效，批量消费和 PullConsumer 不经过中间件。
+多个中间件按传入的顺序从外到内包装，第一个中间件最先拿到消息，最后拿到处理结果。
+
+PublishInterceptor 包装 publisher 的发送，通过 WithPublishInterceptors 指定，拿到的是应用了 PublishOption、
+还没有压缩、加密、签名的消息，拦截器中添加的消息头会被签名覆盖。
+*/
+
+// Middleware consumer 处理函数的中间件
+type Middleware func(next MessageHandler) MessageHandler
+
+// ChainMiddleware 把多个中间件组合为一个，按传入的顺序从外到内包装
+func ChainMiddleware(mws ...Middleware) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		for i := len(mws) - 1; i >= 0; i-- {
+			next = mws[i](next)
+		}
+		return next
+	}
+}
+
+// WithMiddleware 给 consumer 的处理函数添加中间件，可以多次指定，按指定的顺序从外到内包装
+func WithMiddleware(mws ...Middleware) ConsumerOption {
+	return consumerOptionFunc(func(o *consumerOptions) {
+		o.middlewares = append(o.middlewares, mws...)
+	})
+}
+
+// LoggingMiddleware 记录每条消息的处理结果和耗时
+// logger：为空时使用 log 包默认的 logger
+func LoggingMiddleware(logger *log.Logger) Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, msg *Message) error {
+			start := time.Now()
+			err := next(ctx, msg)
+			if err != nil {
+				logger.Printf("consume %s(%s) message %s failed in %s: %v\n", msg.Exchange, msg.RoutingKey, msg.MessageId, time.Since(start), err)
+			} else {
+				logger.Printf("consume %s(%s) message %s done in %s\n", msg.Exchange, msg.RoutingKey, msg.MessageId, time.Since(start))
+			}
+			return err
+		}
+	}
+}
+
+// RecoveryMiddleware 把处理函数的 panic 转换为 PanicError，外层的中间件可以像普通错误一样看到它
+// consumer 本身也会恢复 panic，不使用该中间件时 panic 不会经过外层的中间件
+func RecoveryMiddleware() Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, msg *Message) (err error) {
+			defer func() {
+				if pErr := recover(); pErr != nil {
+					err = &PanicError{Value: pErr, Stack: debug.Stack()}
+				}
+			}()
+			return next(ctx, msg)
+		}
+	}
+}
+
+// TimeoutMiddleware 给处理函数的 ctx 设置超时，处理函数需要关注 ctx 才能及时返回
+// d：超时时间，小于等于 0 时不设置
+func TimeoutMiddleware(d time.Duration) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		if d <= 0 {
+			return next
+		}
+		return func(ctx context.Context, msg *Message) error {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			return next(ctx, msg)
+		}
+	}
+}
+
+// ConsumeMetrics 接收消费的指标，例如对接 prometheus
+type ConsumeMetrics interface {
+	ObserveConsume(msg *Message, duration time.Duration, err error)
+}
+
+// MetricsMiddleware 记录每条消息的处理耗时和结果
+func MetricsMiddleware(m ConsumeMetrics) Middleware {
+	return func(next MessageHandler) MessageHandler {
+		return func(ctx context.Context, msg *Message) error {
+			start := time.Now()
+			err := next(ctx, msg)
+			m.ObserveConsume(msg, time.Since(start), err)
+			return err
+		}
+	}
+}
+
+// PublishFunc 发送消息
+type PublishFunc func(exchange, routingKey string, msg amqp.Publishing) error
+
+// PublishInterceptor publisher 发送的拦截器
+type PublishInterceptor func(next PublishFunc) PublishFunc
+
+// WithPublishInterceptors 给 publisher 添加拦截器，可以多次指定，按指定的顺序从外到内包装
+func WithPublishInterceptors(ics ...PublishInterceptor) PublisherOption {
+	return publisherOptionFunc(func(o *publisherOptions) {
+		o.interceptors = append(o.interceptors, ics...)
+	})
+}
+
+// PublishLoggingInterceptor 记录每条消息的发送结果和耗时
+// logger：为空时使用 log 包默认的 logger
+func PublishLoggingInterceptor(logger *log.Logger) PublishInterceptor {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(next PublishFunc) PublishFunc {
+		return func(exchange, routingKey string, msg amqp.Publishing) error {
+			start := time.Now()
+			err := next(exchange, routingKey, msg)
+			if err != nil {
+				logger.Printf("publish %s(%s) message %s failed in %s: %v\n", exchange, routingKey, msg.MessageId, time.Since(start), err)
+			} else {
+				logger.Printf("publish %s(%s) message %s done in %s\n", exchange, routingKey, msg.MessageId, time.Since(start))
+			}
+			return err
+		}
+	}
+}
+
+// PublishMetrics 接收发送的指标
+type PublishMetrics interface {
+	ObservePublish(exchange, routingKey string, size int, duration time.Duration, err error)
+}
+
+// PublishMetricsInterceptor 记录每条消息的大小、发送耗时和结果，大小为压缩、加密之前的消息体大小
+func PublishMetricsInterceptor(m PublishMetrics) PublishInterceptor {
+	return func(next PublishFunc) PublishFunc {
+		return func(exchange, routingKey string, msg amqp.Publishing) error {
+			start := time.Now()
+			err := next(exchange, routingKey, msg)
+			m.ObservePublish(exchange, routingKey, len(msg.Body), time.Since(start), err)
+			return err
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package rbmq
+
+import (
+	"fmt"
+	"github.com/streadway/amqp"
+	"time"
+)
+
+// IPublisher 各种模式的 publisher 都实现了该接口，可以在其之上扩展功能，例如 TypedPublisher
+type IPublisher interface {
+	PublishMessage(routingKey string, msg amqp.Publishing) error // 发送自定义属性的消息，routingKey 的含义与各模式的 Publish 一致
+}
+
+// PublisherOption publisher 的可选配置，在创建 publisher 时传入
+type PublisherOption interface {
+	applyPublisher(*publisherOptions)
+}
+
+type publisherOptionFunc func(*publisherOptions)
+
+func (f publisherOptionFunc) applyPublisher(o *publisherOptions) {
+	f(o)
+}
+
+// Option 同时适用于 publisher 和 consumer 的可选配置
+type Option interface {
+	PublisherOption
+	ConsumerOption
+}
+
+type publisherOptions struct {
+	rateLimiter *RateLimiter       // 限流，为空不限流
+	compress    *compressOptions   // 压缩，为空不压缩
+	keyProvider KeyProvider        // 加密，为空不加密
+	sign        *signOptions       // 签名，为空不签名
+	claimCheck  *claimCheckOptions // Claim-Check，为空不开启
+	queue       *QueueOptions      // 申请队列的参数
+	exchange    *ExchangeOptions   // 申请交换机的参数
+	deadLetter  bool               // 申请队列时同时申请死信队列
+
+	delayBuckets []time.Duration // DelayModeTTL 允许的延时档位，从小到大
+
+	interceptors []PublishInterceptor // 发送的拦截器
+}
+
+// WithRateLimiter 给 publisher 设置限流器，同一个限流器可以被多个 publisher 共享，共享时按总量限流
+func WithRateLimiter(l *RateLimiter) PublisherOption {
+	return publisherOptionFunc(func(o *publisherOptions) {
+		o.rateLimiter = l
+	})
+}
+
+// BasePublisher 各种模式 publisher 的公共部分，负责按配置处理消息后发送
+type BasePublisher struct {
+	mqConn *RMQConn // 连接
+	opts   publisherOptions
+}
+
+func newPublisherOptions(opts []PublisherOption) publisherOptions {
+	var o publisherOptions
+	for _, opt := range opts {
+		opt.applyPublisher(&o)
+	}
+	return o
+}
+
+func NewBasePublisher(conn *RMQConn, opts ...PublisherOption) *BasePublisher {
+	return &BasePublisher{
+		mqConn: conn,
+		opts:   newPublisherOptions(opts),
+	}
+}
+
+// PublishOption 单条消息的可选配置，在发送消息时传入
+type PublishOption func(msg *amqp.Publishing)
+
+// WithPriority 指定消息的优先级，不能超过 WithQueueOptions 中指定的 MaxPriority
+// 不申请队列的 publisher（routing、topic、订阅模式）也需要通过 WithQueueOptions 指定 MaxPriority 才能发送带优先级的消息
+func WithPriority(priority uint8) PublishOption {
+	return func(msg *amqp.Publishing) {
+		msg.Priority = priority
+	}
+}
+
+// publish 按配置处理消息后发送到指定交换机
+func (p *BasePublisher) publish(exchange, routingKey string, msg amqp.Publishing, opts ...PublishOption) error {
+	for _, opt := range opts {
+		opt(&msg)
+	}
+	send := PublishFunc(p.send)
+	for i := len(p.opts.interceptors) - 1; i >= 0; i-- {
+		send = p.opts.interceptors[i](send)
+	}
+	return send(exchange, routingKey, msg)
+}
+
+// send 压缩、加密、签名等处理之后发送
+func (p *BasePublisher) send(exchange, routingKey string, msg amqp.Publishing) error {
+	if err := p.checkPriority(msg.Priority); err != nil {
+		return err
+	}
+	if p.opts.compress != nil {
+		if err := p.opts.compress.compress(&msg); err != nil {
+			return err
+		}
+	}
+	// 密文无法压缩，所以先压缩再加密
+	if p.opts.keyProvider != nil {
+		if err := encryptPublishing(&msg, p.opts.keyProvider); err != nil {
+			return err
+		}
+	}
+	// 签名覆盖实际发送的内容，所以放在压缩、加密之后
+	if p.opts.sign != nil {
+		if err := p.opts.sign.sign(&msg); err != nil {
+			return err
+		}
+	}
+	// 存储实际要发送的内容，所以放在签名之后
+	var ref string
+	if p.opts.claimCheck != nil {
+		if err := p.opts.claimCheck.checkIn(&msg); err != nil {
+			return err
+		}
+		ref = stringHeader(msg.Headers, claimCheckHeader)
+	}
+	err := p.deliver(exchange, routingKey, msg)
+	if err != nil && ref != "" {
+		// 消息没有发送出去，存储的内容不会再有人取回，删除避免遗留
+		p.opts.claimCheck.discard(ref)
+	}
+	return err
+}
+
+// deliver 限流后发送
+func (p *BasePublisher) deliver(exchange, routingKey string, msg amqp.Publishing) error {
+	// 按实际发送的字节数限流，所以放在最后
+	if p.opts.rateLimiter != nil {
+		if err := p.opts.rateLimiter.Take(len(msg.Body)); err != nil {
+			return err
+		}
+	}
+
+	channel, err := p.mqConn.GetConn().Channel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+	return channel.Publish(
+		exchange,
+		routingKey,
+		false,
+		false,
+		msg,
+	)
+}
+
+// checkPriority 校验消息优先级不超过队列的最大优先级
+func (p *BasePublisher) checkPriority(priority uint8) error {
+	if priority == 0 {
+		return nil
+	}
+	var maxPriority uint8
+	if p.opts.queue != nil {
+		maxPriority = p.opts.queue.MaxPriority
+	}
+	if priority > maxPriority {
+		return fmt.Errorf("%w: priority %d, max priority %d", PriorityOutOfRange, priority, maxPriority)
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package rbmq
+
+import (
+	"context"
+	"errors"
+	"github.com/streadway/amqp"
+	"log"
+	"sync"
+	"time"
+)
+
+/*
+10 Pull 拉模式，consumer 通过 basic.get 主动从队列中拉取消息，而不是由 broker 推送
+
+	应用场景: 消费者性能有限，需要自己控制拉取的速度（参考 REMARK.md 中推模式和拉模式的比较）
+
+Get 拉取一条消息，队列为空时按 WithPullBackoff 指定的间隔轮询，间隔从最小值开始每次翻倍，直到最大值，拉到消息后恢复为最小值。
+拉到的消息需要调用 Ack 或 Nack 确认，没有确认的消息在 Close 或者连接断开后重新入队。
+拉模式每条消息都需要一次请求，吞吐量比推模式低，消费者性能足够时建议使用推模式。
+*/
+
+const (
+	DefaultPullMinBackoff = 50 * time.Millisecond
+	DefaultPullMaxBackoff = 2 * time.Second
+)
+
+// WithPullBackoff 指定 PullConsumer 队列为空时的轮询间隔
+// min：最小间隔
+// max：最大间隔
+func WithPullBackoff(min, max time.Duration) ConsumerOption {
+	return consumerOptionFunc(func(o *consumerOptions) {
+		o.pullMinBackoff = min
+		o.pullMaxBackoff = max
+	})
+}
+
+type PullConsumer struct {
+	mqConn     *RMQConn
+	queueName  string
+	opts       consumerOptions
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	mu          sync.Mutex
+	channel     *amqp.Channel
+	channelDone chan *amqp.Error // 通道关闭时收到通知
+}
+
+// NewPullConsumer 创建拉模式的 consumer
+// conn：rabbit mq 连接
+// queueName：不能为空，不存在时自动创建
+// durable：持久化
+// autoDelete：自动删除
+// opts：可选配置
+func NewPullConsumer(conn *RMQConn, queueName string, durable, autoDelete bool, opts ...ConsumerOption) (*PullConsumer, error) {
+	if conn == nil {
+		return nil, ConnIsNil
+	}
+	if queueName == "" {
+		return nil, QueueNameIsEmpty
+	}
+	channel, err := conn.GetConn().Channel()
+	if err != nil {
+		return nil, err
+	}
+	defer channel.Close()
+
+	o := newConsumerOptions(opts)
+	queueArgs, err := o.queue.arguments(durable, autoDelete)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.deadLetter {
+		queueArgs, err = deadLetterArguments(channel, queueName, queueArgs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 申请队列,如果队列不存在则创建,存在则跳过
+	_, err = channel.QueueDeclare(
+		queueName,
+		durable,
+		autoDelete,
+		false,
+		false,
+		queueArgs,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &PullConsumer{
+		mqConn:     conn,
+		queueName:  queueName,
+		opts:       o,
+		minBackoff: DefaultPullMinBackoff,
+		maxBackoff: DefaultPullMaxBackoff,
+	}
+	if o.pullMinBackoff > 0 {
+		c.minBackoff = o.pullMinBackoff
+	}
+	if o.pullMaxBackoff > 0 {
+		c.maxBackoff = o.pullMaxBackoff
+	}
+	if c.maxBackoff < c.minBackoff {
+		c.maxBackoff = c.minBackoff
+	}
+	return c, nil
+}
+
+// PulledMessage 拉取到的消息，需要调用 Ack 或 Nack 确认
+type PulledMessage struct {
+	*Message
+	delivery amqp.Delivery
+	ref      string // Claim-Check 的引用
+	opts     *consumerOptions
+}
+
+// Ack 确认消息已经处理
+func (m *PulledMessage) Ack() error {
+	if err := m.delivery.Ack(false); err != nil {
+		return err
+	}
+	m.opts.claimCheck.release(m.ref)
+	return nil
+}
+
+// Nack 处理失败
+// requeue：是否重新入队，为 false 时配置了死信的队列会转入死信
+func (m *PulledMessage) Nack(requeue bool) error {
+	return m.delivery.Nack(false, requeue)
+}
+
+// Get 拉取一条消息，队列为空时轮询直到拉到消息或者 ctx 结束
+func (c *PullConsumer) Get(ctx context.Context) (*PulledMessage, error) {
+	backoff := c.minBackoff
+	for {
+		msg, ok, err := c.get()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return msg, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > c.maxBackoff {
+			backoff = c.maxBackoff
+		}
+	}
+}
+
+// GetBatch 拉取最多 n 条消息，等待第一条消息的方式与 Get 相同，之后队列为空时立即返回已经拉到的消息
+func (c *PullConsumer) GetBatch(ctx context.Context, n int) ([]*PulledMessage, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	first, err := c.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	msgs := []*PulledMessage{first}
+	for len(msgs) < n {
+		if ctx.Err() != nil {
+			break
+		}
+		msg, ok, err := c.get()
+		if err != nil {
+			// 已经拉到的消息返回给调用方处理，错误在下一次拉取时返回
+			log.Printf("PullConsumer: %s\n", err)
+			break
+		}
+		if !ok {
+			break
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// get 拉取一条消息，队列为空时 ok 为 false，无法还原消息体的消息按推模式的规则 nack 后继续拉取
+func (c *PullConsumer) get() (*PulledMessage, bool, error) {
+	channel, err := c.getChannel()
+	if err != nil {
+		return nil, false, err
+	}
+	for {
+		d, ok, err := channel.Get(c.queueName, false)
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		ref := claimCheckRef(&d)
+		if err = c.opts.decode(&d); err != nil {
+			log.Printf("PullConsumer: %s\n", err)
+			if c.opts.errorHook != nil {
+				c.opts.errorHook(newMessage(&d), err)
+			}
+			var fErr *claimCheckFetchError
+			if err = d.Nack(false, errors.As(err, &fErr)); err != nil {
+				return nil, false, err
+			}
+			continue
+		}
+		return &PulledMessage{
+			Message:  newMessage(&d),
+			delivery: d,
+			ref:      ref,
+			opts:     &c.opts,
+		}, true, nil
+	}
+}
+
+// getChannel 返回拉取消息的通道，通道关闭（例如断网重连）后重新打开
+// 同一个通道拉取的消息需要在该通道上确认，所以通道没有关闭时一直使用同一个
+func (c *PullConsumer) getChannel() (*amqp.Channel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.channel != nil {
+		select {
+		case <-c.channelDone:
+		default:
+			return c.channel, nil
+		}
+	}
+	channel, err := c.mqConn.GetConn().Channel()
+	if err != nil {
+		return nil, err
+	}
+	c.channel = channel
+	c.channelDone = channel.NotifyClose(make(chan *amqp.Error, 1))
+	return channel, nil
+}
+
+// Close 关闭拉取消息的通道，没有确认的消息重新入队，注意不会关闭连接，因为连接可能不是独占的
+func (c *PullConsumer) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.channel == nil {
+		return nil
+	}
+	err := c.channel.Close()
+	c.channel = nil
+	return err
+}
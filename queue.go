@@ -0,0 +1,168 @@
+package rbmq
+
+import (
+	"fmt"
+	"github.com/streadway/amqp"
+	"time"
+)
+
+/*
+关于队列参数
+通过 WithQueueOptions 在创建 publisher 和 consumer 时指定队列参数，只对构造函数中申请的队列生效
+（simple 模式的 publisher 以及各模式的 consumer），routing、topic、订阅模式的 publisher 不申请队列，会忽略该配置。
+同名队列已经存在且参数不一致时，rabbitmq 会返回 PRECONDITION_FAILED，publisher 与 consumer 需要使用相同的队列参数。
+
+(1) quorum 仲裁队列：基于 raft 的高可用队列，必须持久化且不能自动删除，不支持 lazy 模式和 reject-publish-dlx
+(2) stream 流队列：只追加的日志，可以重复读取历史消息，必须持久化且不能自动删除，不支持消息 TTL、死信、x-overflow 和 x-expires
+(3) lazy 模式：消息尽可能存放在磁盘上，减少内存占用，仅 classic 队列支持
+(4) 优先级队列：MaxPriority 大于 0 时队列支持优先级，优先级高的消息先被消费，仅 classic 队列支持，
+优先级越多 broker 的开销越大，建议不超过 10
+*/
+
+type QueueType string
+
+const (
+	QueueTypeClassic QueueType = "classic"
+	QueueTypeQuorum  QueueType = "quorum"
+	QueueTypeStream  QueueType = "stream"
+)
+
+// OverflowMode 队列达到最大长度后的处理方式
+type OverflowMode string
+
+const (
+	OverflowDropHead         OverflowMode = "drop-head"          // 丢弃（或死信）队头的消息，默认
+	OverflowRejectPublish    OverflowMode = "reject-publish"     // 拒绝新发布的消息
+	OverflowRejectPublishDLX OverflowMode = "reject-publish-dlx" // 拒绝新发布的消息并转入死信
+)
+
+// QueueOptions 队列参数，零值表示不设置
+type QueueOptions struct {
+	Type                 QueueType     // 队列类型，为空时为 classic
+	Lazy                 bool          // lazy 模式
+	MaxLength            int64         // 最大消息条数
+	MaxLengthBytes       int64         // 最大消息字节数
+	Overflow             OverflowMode  // 达到最大长度后的处理方式
+	MessageTTL           time.Duration // 消息过期时间，毫秒精度
+	Expires              time.Duration // 队列闲置（没有消费者）多久后自动删除，毫秒精度
+	DeadLetterExchange   string        // 死信交换机，为空时不设置
+	DeadLetterRoutingKey string        // 死信路由 key，为空时沿用消息原来的路由 key
+	MaxPriority          uint8         // 最大优先级，大于 0 时为优先级队列，建议不超过 10
+	Args                 amqp.Table    // 其他参数，与上面的字段冲突时以上面的字段为准
+}
+
+// WithQueueOptions 指定构造函数中申请的队列的参数
+func WithQueueOptions(q QueueOptions) Option {
+	return queueOption{q: q}
+}
+
+type queueOption struct {
+	q QueueOptions
+}
+
+func (o queueOption) applyPublisher(p *publisherOptions) {
+	p.queue = &o.q
+}
+
+func (o queueOption) applyConsumer(c *consumerOptions) {
+	c.queue = &o.q
+}
+
+// Validate 校验队列参数
+// durable、autoDelete：申请队列时的持久化和自动删除参数，quorum、stream 队列有要求
+func (o *QueueOptions) Validate(durable, autoDelete bool) error {
+	switch o.Type {
+	case "", QueueTypeClassic:
+	case QueueTypeQuorum, QueueTypeStream:
+		if !durable || autoDelete {
+			return fmt.Errorf("%w: %s queue must be durable and not auto-delete", QueueOptionsInvalid, o.Type)
+		}
+		if o.Lazy {
+			return fmt.Errorf("%w: lazy mode is only supported by classic queues", QueueOptionsInvalid)
+		}
+	default:
+		return fmt.Errorf("%w: unknown queue type %q", QueueOptionsInvalid, o.Type)
+	}
+
+	switch o.Overflow {
+	case "", OverflowDropHead, OverflowRejectPublish:
+	case OverflowRejectPublishDLX:
+		if o.Type == QueueTypeQuorum {
+			return fmt.Errorf("%w: quorum queue does not support %s", QueueOptionsInvalid, o.Overflow)
+		}
+	default:
+		return fmt.Errorf("%w: unknown overflow mode %q", QueueOptionsInvalid, o.Overflow)
+	}
+
+	if o.MaxLength < 0 || o.MaxLengthBytes < 0 {
+		return fmt.Errorf("%w: max length must not be negative", QueueOptionsInvalid)
+	}
+	if o.MessageTTL < 0 || o.Expires < 0 {
+		return fmt.Errorf("%w: message ttl and expires must not be negative", QueueOptionsInvalid)
+	}
+	if o.Expires > 0 && o.Expires < time.Millisecond {
+		return fmt.Errorf("%w: expires must be at least 1ms", QueueOptionsInvalid)
+	}
+	if o.DeadLetterRoutingKey != "" && o.DeadLetterExchange == "" {
+		return fmt.Errorf("%w: dead letter routing key requires dead letter exchange", QueueOptionsInvalid)
+	}
+
+	if o.MaxPriority > 0 && o.Type != "" && o.Type != QueueTypeClassic {
+		return fmt.Errorf("%w: priority is only supported by classic queues", QueueOptionsInvalid)
+	}
+
+	if o.Type == QueueTypeStream {
+		if o.Overflow != "" || o.MessageTTL > 0 || o.Expires > 0 || o.DeadLetterExchange != "" {
+			return fmt.Errorf("%w: stream queue does not support overflow, message ttl, expires or dead lettering", QueueOptionsInvalid)
+		}
+	}
+	return nil
+}
+
+// arguments 校验并转换为申请队列的参数，o 为空时返回 nil
+func (o *QueueOptions) arguments(durable, autoDelete bool) (amqp.Table, error) {
+	if o == nil {
+		return nil, nil
+	}
+	if err := o.Validate(durable, autoDelete); err != nil {
+		return nil, err
+	}
+	args := make(amqp.Table, len(o.Args)+10)
+	for k, v := range o.Args {
+		args[k] = v
+	}
+	if o.Type != "" {
+		args["x-queue-type"] = string(o.Type)
+	}
+	if o.Lazy {
+		args["x-queue-mode"] = "lazy"
+	}
+	if o.MaxLength > 0 {
+		args["x-max-length"] = o.MaxLength
+	}
+	if o.MaxLengthBytes > 0 {
+		args["x-max-length-bytes"] = o.MaxLengthBytes
+	}
+	if o.Overflow != "" {
+		args["x-overflow"] = string(o.Overflow)
+	}
+	if o.MessageTTL > 0 {
+		args["x-message-ttl"] = o.MessageTTL.Milliseconds()
+	}
+	if o.Expires > 0 {
+		args["x-expires"] = o.Expires.Milliseconds()
+	}
+	if o.DeadLetterExchange != "" {
+		args["x-dead-letter-exchange"] = o.DeadLetterExchange
+	}
+	if o.DeadLetterRoutingKey != "" {
+		args["x-dead-letter-routing-key"] = o.DeadLetterRoutingKey
+	}
+	if o.MaxPriority > 0 {
+		args["x-max-priority"] = int(o.MaxPriority)
+	}
+	if len(args) == 0 {
+		return nil, nil
+	}
+	return args, nil
+}
@@ -0,0 +1,164 @@
+package rbmq
+
+import (
+	"errors"
+	"github.com/streadway/amqp"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQueueOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       QueueOptions
+		durable    bool
+		autoDelete bool
+		wantErr    bool
+	}{
+		{name: "zero value", opts: QueueOptions{}},
+		{name: "classic", opts: QueueOptions{Type: QueueTypeClassic}},
+		{name: "unknown type", opts: QueueOptions{Type: "mirrored"}, durable: true, wantErr: true},
+
+		{name: "quorum durable", opts: QueueOptions{Type: QueueTypeQuorum}, durable: true},
+		{name: "quorum not durable", opts: QueueOptions{Type: QueueTypeQuorum}, wantErr: true},
+		{name: "quorum auto delete", opts: QueueOptions{Type: QueueTypeQuorum}, durable: true, autoDelete: true, wantErr: true},
+		{name: "stream durable", opts: QueueOptions{Type: QueueTypeStream}, durable: true},
+		{name: "stream not durable", opts: QueueOptions{Type: QueueTypeStream}, wantErr: true},
+		{name: "stream auto delete", opts: QueueOptions{Type: QueueTypeStream}, durable: true, autoDelete: true, wantErr: true},
+
+		{name: "classic lazy", opts: QueueOptions{Lazy: true}},
+		{name: "quorum lazy", opts: QueueOptions{Type: QueueTypeQuorum, Lazy: true}, durable: true, wantErr: true},
+		{name: "stream lazy", opts: QueueOptions{Type: QueueTypeStream, Lazy: true}, durable: true, wantErr: true},
+
+		{name: "drop head", opts: QueueOptions{MaxLength: 10, Overflow: OverflowDropHead}},
+		{name: "reject publish", opts: QueueOptions{MaxLength: 10, Overflow: OverflowRejectPublish}},
+		{name: "classic reject publish dlx", opts: QueueOptions{MaxLength: 10, Overflow: OverflowRejectPublishDLX}},
+		{name: "quorum reject publish", opts: QueueOptions{Type: QueueTypeQuorum, Overflow: OverflowRejectPublish}, durable: true},
+		{name: "quorum reject publish dlx", opts: QueueOptions{Type: QueueTypeQuorum, Overflow: OverflowRejectPublishDLX}, durable: true, wantErr: true},
+		{name: "unknown overflow", opts: QueueOptions{Overflow: "drop-tail"}, wantErr: true},
+
+		{name: "negative max length", opts: QueueOptions{MaxLength: -1}, wantErr: true},
+		{name: "negative max length bytes", opts: QueueOptions{MaxLengthBytes: -1}, wantErr: true},
+		{name: "negative message ttl", opts: QueueOptions{MessageTTL: -time.Second}, wantErr: true},
+		{name: "negative expires", opts: QueueOptions{Expires: -time.Second}, wantErr: true},
+		{name: "expires below 1ms", opts: QueueOptions{Expires: time.Microsecond}, wantErr: true},
+		{name: "expires 1ms", opts: QueueOptions{Expires: time.Millisecond}},
+
+		{name: "dead letter exchange", opts: QueueOptions{DeadLetterExchange: "dlx"}},
+		{name: "dead letter routing key", opts: QueueOptions{DeadLetterExchange: "dlx", DeadLetterRoutingKey: "dlq"}},
+		{name: "dead letter routing key without exchange", opts: QueueOptions{DeadLetterRoutingKey: "dlq"}, wantErr: true},
+
+		{name: "classic priority", opts: QueueOptions{MaxPriority: 10}},
+		{name: "explicit classic priority", opts: QueueOptions{Type: QueueTypeClassic, MaxPriority: 10}},
+		{name: "quorum priority", opts: QueueOptions{Type: QueueTypeQuorum, MaxPriority: 10}, durable: true, wantErr: true},
+		{name: "stream priority", opts: QueueOptions{Type: QueueTypeStream, MaxPriority: 10}, durable: true, wantErr: true},
+
+		{name: "stream max length", opts: QueueOptions{Type: QueueTypeStream, MaxLengthBytes: 1 << 30}, durable: true},
+		{name: "stream overflow", opts: QueueOptions{Type: QueueTypeStream, Overflow: OverflowDropHead}, durable: true, wantErr: true},
+		{name: "stream message ttl", opts: QueueOptions{Type: QueueTypeStream, MessageTTL: time.Second}, durable: true, wantErr: true},
+		{name: "stream expires", opts: QueueOptions{Type: QueueTypeStream, Expires: time.Second}, durable: true, wantErr: true},
+		{name: "stream dead letter", opts: QueueOptions{Type: QueueTypeStream, DeadLetterExchange: "dlx"}, durable: true, wantErr: true},
+		{name: "quorum dead letter", opts: QueueOptions{Type: QueueTypeQuorum, DeadLetterExchange: "dlx", MessageTTL: time.Second}, durable: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate(tt.durable, tt.autoDelete)
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("Validate = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, QueueOptionsInvalid) {
+				t.Fatalf("Validate = %v, want QueueOptionsInvalid", err)
+			}
+			// arguments 同样要拒绝无效参数
+			if args, err := tt.opts.arguments(tt.durable, tt.autoDelete); !errors.Is(err, QueueOptionsInvalid) || args != nil {
+				t.Errorf("arguments = %v, %v, want nil, QueueOptionsInvalid", args, err)
+			}
+		})
+	}
+}
+
+func TestQueueOptionsArguments(t *testing.T) {
+	tests := []struct {
+		name string
+		opts *QueueOptions
+		want amqp.Table
+	}{
+		{name: "nil options", opts: nil, want: nil},
+		{name: "zero value", opts: &QueueOptions{}, want: nil},
+		{name: "classic type", opts: &QueueOptions{Type: QueueTypeClassic}, want: amqp.Table{"x-queue-type": "classic"}},
+		{name: "lazy", opts: &QueueOptions{Lazy: true}, want: amqp.Table{"x-queue-mode": "lazy"}},
+		{
+			name: "length limits",
+			opts: &QueueOptions{MaxLength: 100, MaxLengthBytes: 1 << 20, Overflow: OverflowRejectPublish},
+			want: amqp.Table{
+				"x-max-length":       int64(100),
+				"x-max-length-bytes": int64(1 << 20),
+				"x-overflow":         "reject-publish",
+			},
+		},
+		{
+			name: "durations in milliseconds",
+			opts: &QueueOptions{MessageTTL: 1500 * time.Millisecond, Expires: time.Minute + 999*time.Microsecond},
+			want: amqp.Table{
+				"x-message-ttl": int64(1500),
+				"x-expires":     int64(60000),
+			},
+		},
+		{
+			name: "dead letter",
+			opts: &QueueOptions{DeadLetterExchange: "dlx", DeadLetterRoutingKey: "orders.dlq"},
+			want: amqp.Table{
+				"x-dead-letter-exchange":    "dlx",
+				"x-dead-letter-routing-key": "orders.dlq",
+			},
+		},
+		{name: "priority", opts: &QueueOptions{MaxPriority: 10}, want: amqp.Table{"x-max-priority": 10}},
+		{
+			name: "quorum",
+			opts: &QueueOptions{Type: QueueTypeQuorum, MaxLength: 1000, Overflow: OverflowRejectPublish, DeadLetterExchange: "dlx"},
+			want: amqp.Table{
+				"x-queue-type":           "quorum",
+				"x-max-length":           int64(1000),
+				"x-overflow":             "reject-publish",
+				"x-dead-letter-exchange": "dlx",
+			},
+		},
+		{
+			name: "stream",
+			opts: &QueueOptions{Type: QueueTypeStream, MaxLengthBytes: 1 << 30},
+			want: amqp.Table{
+				"x-queue-type":       "stream",
+				"x-max-length-bytes": int64(1 << 30),
+			},
+		},
+		{
+			name: "extra args",
+			opts: &QueueOptions{Args: amqp.Table{"x-single-active-consumer": true}},
+			want: amqp.Table{"x-single-active-consumer": true},
+		},
+		{
+			name: "fields override args",
+			opts: &QueueOptions{MaxLength: 5, Args: amqp.Table{"x-max-length": int64(1), "x-queue-leader-locator": "balanced"}},
+			want: amqp.Table{"x-max-length": int64(5), "x-queue-leader-locator": "balanced"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// quorum、stream 队列要求持久化，其他参数与持久化无关
+			got, err := tt.opts.arguments(true, false)
+			if err != nil {
+				t.Fatalf("arguments: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("arguments = %#v, want %#v", got, tt.want)
+			}
+			if err = got.Validate(); err != nil {
+				t.Errorf("arguments are not valid amqp fields: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package rbmq
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+关于发布限流
+使用令牌桶算法，分别按消息条数和消息字节数限流，两者可以同时生效。令牌按速率持续补充，桶的容量为 1 秒的速率，
+即允许最多 1 秒的突发流量。单条消息超过桶容量时，只要桶是满的就允许发送，欠下的令牌由后续的补充偿还，
+保证大消息不会永远发不出去，同时长期速率依然受控。
+
+令牌不足时有两种处理方式，通过 RateLimitMode 选择：
+(1) RateLimitBlock：阻塞等待，直到令牌足够
+(2) RateLimitReject：立即返回 *RateLimitError，可以用 errors.Is(err, RateLimitExceeded) 判断
+*/
+
+type RateLimitMode int
+
+const (
+	RateLimitBlock  RateLimitMode = iota // 令牌不足时阻塞等待
+	RateLimitReject                      // 令牌不足时返回错误
+)
+
+// RateLimitError 令牌不足时返回的错误
+type RateLimitError struct {
+	Limit      string        // 触发限流的维度，messages 或 bytes
+	RetryAfter time.Duration // 令牌足够需要等待的时间
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("publish rate limit exceeded on %s, retry after %s", e.Limit, e.RetryAfter)
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == RateLimitExceeded
+}
+
+type tokenBucket struct {
+	rate   float64 // 每秒补充的令牌数
+	burst  float64 // 桶的容量
+	tokens float64 // 当前令牌数，可以为负数，表示欠下的令牌
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, now time.Time) *tokenBucket {
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   now,
+	}
+}
+
+// refill 补充从上次到 now 之间产生的令牌
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// wait 取 n 个令牌需要等待的时间，桶满时允许透支
+func (b *tokenBucket) wait(n float64) time.Duration {
+	if b.tokens >= n || b.tokens >= b.burst {
+		return 0
+	}
+	need := n
+	if need > b.burst {
+		need = b.burst
+	}
+	return time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+}
+
+type RateLimiter struct {
+	mu    sync.Mutex
+	mode  RateLimitMode
+	msgs  *tokenBucket // 按条数限流，为空不限制
+	bytes *tokenBucket // 按字节数限流，为空不限制
+}
+
+// NewRateLimiter 创建限流器
+// msgsPerSecond：每秒允许发送的消息条数，小于等于 0 表示不限制
+// bytesPerSecond：每秒允许发送的消息字节数，小于等于 0 表示不限制
+// mode：令牌不足时的处理方式
+func NewRateLimiter(msgsPerSecond, bytesPerSecond float64, mode RateLimitMode) *RateLimiter {
+	now := time.Now()
+	l := &RateLimiter{
+		mode: mode,
+	}
+	if msgsPerSecond > 0 {
+		l.msgs = newTokenBucket(msgsPerSecond, now)
+	}
+	if bytesPerSecond > 0 {
+		l.bytes = newTokenBucket(bytesPerSecond, now)
+	}
+	return l
+}
+
+// Take 发送一条 size 字节的消息前获取令牌，RateLimitBlock 模式下会阻塞直到令牌足够
+func (l *RateLimiter) Take(size int) error {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		var waitTime time.Duration
+		var limit string
+		if l.msgs != nil {
+			l.msgs.refill(now)
+			if w := l.msgs.wait(1); w > waitTime {
+				waitTime, limit = w, "messages"
+			}
+		}
+		if l.bytes != nil {
+			l.bytes.refill(now)
+			if w := l.bytes.wait(float64(size)); w > waitTime {
+				waitTime, limit = w, "bytes"
+			}
+		}
+		if waitTime == 0 {
+			if l.msgs != nil {
+				l.msgs.tokens--
+			}
+			if l.bytes != nil {
+				l.bytes.tokens -= float64(size)
+			}
+			l.mu.Unlock()
+			return nil
+		}
+		l.mu.Unlock()
+
+		if l.mode == RateLimitReject {
+			return &RateLimitError{Limit: limit, RetryAfter: waitTime}
+		}
+		// 等待后重新检查，期间令牌可能被共享该限流器的其他 publisher 取走
+		time.Sleep(waitTime)
+	}
+}
@@ -0,0 +1,116 @@
+package rbmq
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	tests := []struct {
+		name       string
+		rate       float64
+		tokens     float64       // refill 前的令牌数
+		elapsed    time.Duration // 距上次补充的时间
+		n          float64       // 要取的令牌数
+		wantTokens float64       // refill 后的令牌数
+		wantWait   time.Duration
+	}{
+		{name: "enough tokens", rate: 10, tokens: 10, n: 1, wantTokens: 10},
+		{name: "refill capped at burst", rate: 10, tokens: 5, elapsed: 2 * time.Second, n: 1, wantTokens: 10},
+		{name: "partial refill", rate: 10, tokens: 0, elapsed: 200 * time.Millisecond, n: 5, wantTokens: 2, wantWait: 300 * time.Millisecond},
+		{name: "oversized overdraws full bucket", rate: 10, tokens: 10, n: 25, wantTokens: 10},
+		{name: "oversized waits for full bucket", rate: 10, tokens: 4, n: 25, wantTokens: 4, wantWait: 600 * time.Millisecond},
+		{name: "debt repaid before next take", rate: 10, tokens: -15, n: 1, wantTokens: -15, wantWait: 1600 * time.Millisecond},
+		{name: "refill repays debt", rate: 10, tokens: -15, elapsed: time.Second, n: 1, wantTokens: -5, wantWait: 600 * time.Millisecond},
+		{name: "burst at least one", rate: 0.5, tokens: 0, n: 1, wantTokens: 0, wantWait: 2 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			b := newTokenBucket(tt.rate, start)
+			b.tokens = tt.tokens
+			b.refill(start.Add(tt.elapsed))
+			if math.Abs(b.tokens-tt.wantTokens) > 1e-9 {
+				t.Errorf("tokens = %v, want %v", b.tokens, tt.wantTokens)
+			}
+			if got := b.wait(tt.n); absDuration(got-tt.wantWait) > time.Microsecond {
+				t.Errorf("wait(%v) = %s, want %s", tt.n, got, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestRateLimiterTakeReject(t *testing.T) {
+	tests := []struct {
+		name           string
+		msgsPerSecond  float64
+		bytesPerSecond float64
+		sizes          []int         // 依次发送的消息大小，除最后一条外都应该成功
+		wantLimit      string        // 最后一条触发限流的维度，为空表示最后一条也应该成功
+		wantRetryAfter time.Duration // 最后一条的 RetryAfter
+	}{
+		{name: "unlimited", sizes: []int{1 << 20, 1 << 20, 1 << 20}},
+		{name: "messages", msgsPerSecond: 2, sizes: []int{1, 1, 1}, wantLimit: "messages", wantRetryAfter: 500 * time.Millisecond},
+		{name: "bytes", bytesPerSecond: 100, sizes: []int{60, 60}, wantLimit: "bytes", wantRetryAfter: 200 * time.Millisecond},
+		{name: "oversized overdraws full bucket", bytesPerSecond: 100, sizes: []int{250}},
+		{name: "overdraft repaid before next take", bytesPerSecond: 100, sizes: []int{250, 1}, wantLimit: "bytes", wantRetryAfter: 1510 * time.Millisecond},
+		{name: "longer wait wins", msgsPerSecond: 2, bytesPerSecond: 100, sizes: []int{50, 50, 100}, wantLimit: "bytes", wantRetryAfter: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewRateLimiter(tt.msgsPerSecond, tt.bytesPerSecond, RateLimitReject)
+			last := len(tt.sizes) - 1
+			for i, size := range tt.sizes[:last] {
+				if err := l.Take(size); err != nil {
+					t.Fatalf("Take #%d: %v", i, err)
+				}
+			}
+			err := l.Take(tt.sizes[last])
+			if tt.wantLimit == "" {
+				if err != nil {
+					t.Fatalf("Take #%d: %v", last, err)
+				}
+				return
+			}
+			if !errors.Is(err, RateLimitExceeded) {
+				t.Fatalf("Take #%d = %v, want RateLimitExceeded", last, err)
+			}
+			var rateErr *RateLimitError
+			if !errors.As(err, &rateErr) {
+				t.Fatalf("Take #%d = %T, want *RateLimitError", last, err)
+			}
+			if rateErr.Limit != tt.wantLimit {
+				t.Errorf("Limit = %s, want %s", rateErr.Limit, tt.wantLimit)
+			}
+			// 测试执行期间令牌在补充，RetryAfter 只会比预期略短
+			if rateErr.RetryAfter > tt.wantRetryAfter || rateErr.RetryAfter < tt.wantRetryAfter-100*time.Millisecond {
+				t.Errorf("RetryAfter = %s, want about %s", rateErr.RetryAfter, tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func TestRateLimiterRejectDoesNotTakeTokens(t *testing.T) {
+	l := NewRateLimiter(1, 0, RateLimitReject)
+	if err := l.Take(0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := l.Take(0); !errors.Is(err, RateLimitExceeded) {
+			t.Fatalf("Take = %v, want RateLimitExceeded", err)
+		}
+	}
+	// 被拒绝的请求没有欠下令牌，1 秒后可以再次发送
+	if l.msgs.tokens < -1e-3 {
+		t.Errorf("tokens = %v after rejected takes, want about 0", l.msgs.tokens)
+	}
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
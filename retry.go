@@ -0,0 +1,283 @@
+package rbmq
+
+import (
+	"errors"
+	"fmt"
+	"github.com/streadway/amqp"
+	"log"
+	"strings"
+	"time"
+)
+
+/*
+关于重试
+处理函数返回错误时默认 nack 并重新入队，消息会立即再次投递，处理一直失败时会形成热循环。
+通过 WithRetryPolicy 指定重试策略后，处理失败的消息按以下方式重试：
+
+(1) 消息被重新发送到延时队列 <queue>.retry.<毫秒数>，该队列设置了 x-message-ttl，没有消费者，
+消息过期后通过默认交换机死信回原队列，再次投递给 consumer。每种延时对应一个队列，与 DelayModeTTL 相同不会出现队头阻塞。
+(2) 已经重试的次数记录在消息头 x-retry-attempt 中，第一次失败时把消息原来的交换机和路由 key 记录在
+x-original-exchange、x-original-routing-key 中，最后一次的错误记录在 x-last-error 中。
+(3) 尝试次数（包括第一次处理）达到 MaxAttempts，或者处理函数返回 Reject 包装的错误时，消息被发送到死信队列 <queue>.dlq，不再重试。
+
+重试队列和死信队列以队列名为前缀，所以队列名为空（由 broker 生成）的 consumer 不支持重试策略，创建时返回错误。
+重新发送使用 publisher confirm 和 mandatory，broker 确认收到后才 ack 原消息，发送失败时 nack 原消息并重新入队，消息不会丢失，但可能重复。
+重试队列和死信队列在外部被删除时消息会被退回，同样按发送失败处理，并在下次重试时重新申请队列。
+重新发送的是 consumer 收到的原始消息体（压缩、加密、签名、Claim-Check 保持不变），重试前不会删除 Claim-Check 存储的内容。
+*/
+
+const (
+	retryAttemptHeader       = "x-retry-attempt"
+	lastErrorHeader          = "x-last-error"
+	originalExchangeHeader   = "x-original-exchange"
+	originalRoutingKeyHeader = "x-original-routing-key"
+	deadLetterQueueSuffix    = ".dlq"
+)
+
+// RetryPolicy 重试策略
+type RetryPolicy struct {
+	MaxAttempts int             // 最多处理的次数，包括第一次，小于等于 1 时不重试，失败后直接进入死信队列
+	Backoff     []time.Duration // 第 n 次重试前的等待时间，重试次数超过长度时使用最后一个，为空时为 1 秒，毫秒精度
+}
+
+// ExponentialBackoff 生成指数增长的等待时间，每次翻倍，不超过 max
+// initial：第一次重试的等待时间
+// max：最长等待时间
+// n：生成的个数
+func ExponentialBackoff(initial, max time.Duration, n int) []time.Duration {
+	backoff := make([]time.Duration, 0, n)
+	d := initial
+	for i := 0; i < n; i++ {
+		if d > max {
+			d = max
+		}
+		backoff = append(backoff, d)
+		d *= 2
+	}
+	return backoff
+}
+
+// WithRetryPolicy 指定处理失败时的重试策略，不指定时 nack 并重新入队
+func WithRetryPolicy(p RetryPolicy) ConsumerOption {
+	return consumerOptionFunc(func(o *consumerOptions) {
+		o.retry = &p
+	})
+}
+
+// checkRetry 配置了重试策略时校验队列名
+// 队列名为空时 broker 生成的队列名以 amq. 开头，无法以它为前缀申请重试队列和死信队列，重试会一直失败并重新入队
+func (o *consumerOptions) checkRetry(queueName string) error {
+	if o.retry != nil && queueName == "" {
+		return fmt.Errorf("%w: retry policy requires a queue name", QueueOptionsInvalid)
+	}
+	return nil
+}
+
+// delay 第 attempt 次重试前的等待时间，attempt 从 1 开始
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	if len(p.Backoff) == 0 {
+		return time.Second
+	}
+	if attempt > len(p.Backoff) {
+		attempt = len(p.Backoff)
+	}
+	d := p.Backoff[attempt-1]
+	if d < time.Millisecond {
+		d = time.Millisecond
+	}
+	return d
+}
+
+// DeadLetterQueueName 返回队列对应的死信队列名
+func DeadLetterQueueName(queueName string) string {
+	return queueName + deadLetterQueueSuffix
+}
+
+// isRetryQueue 判断 name 是否是队列对应的重试队列
+func isRetryQueue(name, queueName string) bool {
+	return strings.HasPrefix(name, queueName+".retry.")
+}
+
+// retryAttempt 返回消息已经重试的次数
+func retryAttempt(headers amqp.Table) int {
+	switch v := headers[retryAttemptHeader].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
+
+// retry 处理失败后把原始消息发送到重试队列或死信队列，确认发送成功后 ack 原消息
+// raw：consumer 收到的原始消息
+// hErr：处理函数返回的错误
+func (c *BaseConsumer) retry(raw amqp.Delivery, hErr error) {
+	p := c.opts.retry
+	attempt := retryAttempt(raw.Headers) + 1 // 包括本次在内已经处理的次数
+
+	headers := make(amqp.Table, len(raw.Headers)+4)
+	for k, v := range raw.Headers {
+		headers[k] = v
+	}
+	if _, ok := headers[originalExchangeHeader]; !ok {
+		headers[originalExchangeHeader] = raw.Exchange
+		headers[originalRoutingKeyHeader] = raw.RoutingKey
+	}
+	headers[retryAttemptHeader] = int64(attempt)
+	headers[lastErrorHeader] = hErr.Error()
+
+	var rErr *rejectError
+	var queue string
+	var err error
+	dead := errors.As(hErr, &rErr) || attempt >= p.MaxAttempts
+	delay := p.delay(attempt)
+	if dead {
+		queue, err = c.deadLetterQueue()
+	} else {
+		queue, err = c.declareRetryQueue(delay)
+	}
+	if err == nil {
+		err = c.republish(queue, raw, headers)
+		if errors.Is(err, MessageUnroutable) {
+			// 队列已经在外部被删除，清除缓存，下次重新申请
+			if dead {
+				c.deadLetterDeclared.Store(false)
+			} else {
+				c.retryQueues.Delete(delay.Milliseconds())
+			}
+		}
+	}
+	if err != nil {
+		// 发送失败时重新入队，稍后再处理
+		log.Printf("consumeHandle: retry: %s\n", err)
+		if err = raw.Nack(false, true); err != nil {
+			log.Printf("deliver.Nack: %s\n", err)
+		}
+		return
+	}
+	if err = raw.Ack(false); err != nil {
+		log.Printf("deliver.Ack: %s\n", err)
+	}
+}
+
+// republish 通过默认交换机把原始消息发送到指定队列，等待 broker 确认
+func (c *BaseConsumer) republish(queue string, raw amqp.Delivery, headers amqp.Table) error {
+	channel, err := c.mqConn.GetConn().Channel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+
+	confirms, returns, err := confirmMode(channel)
+	if err != nil {
+		return err
+	}
+	return publishMandatory(channel, confirms, returns, "", queue, amqp.Publishing{
+		Headers:         headers,
+		ContentType:     raw.ContentType,
+		ContentEncoding: raw.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		Priority:        raw.Priority,
+		CorrelationId:   raw.CorrelationId,
+		ReplyTo:         raw.ReplyTo,
+		MessageId:       raw.MessageId,
+		Timestamp:       raw.Timestamp,
+		Type:            raw.Type,
+		UserId:          raw.UserId,
+		AppId:           raw.AppId,
+		Body:            raw.Body,
+	})
+}
+
+type amqpPublisher interface {
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+// confirmMode 开启 publisher confirm，并监听被退回的 mandatory 消息
+func confirmMode(channel *amqp.Channel) (<-chan amqp.Confirmation, <-chan amqp.Return, error) {
+	if err := channel.Confirm(false); err != nil {
+		return nil, nil, err
+	}
+	returns := channel.NotifyReturn(make(chan amqp.Return, 1))
+	confirms := channel.NotifyPublish(make(chan amqp.Confirmation, 1))
+	return confirms, returns, nil
+}
+
+// publishMandatory 以 mandatory 发送一条消息并等待 broker 确认，消息没有被路由到任何队列时返回 MessageUnroutable
+// 无法路由的消息 broker 同样会确认，但会在确认之前退回，所以收到确认时检查是否有退回的消息
+// channel 上同时只能有一条等待确认的消息
+func publishMandatory(channel amqpPublisher, confirms <-chan amqp.Confirmation, returns <-chan amqp.Return,
+	exchange, routingKey string, msg amqp.Publishing) error {
+	if err := channel.Publish(exchange, routingKey, true, false, msg); err != nil {
+		return err
+	}
+	confirm, ok := <-confirms
+	if !ok {
+		return errors.New("channel closed before publish confirm")
+	}
+	select {
+	case ret := <-returns:
+		return fmt.Errorf("%w: %s(%s) %s", MessageUnroutable, exchange, routingKey, ret.ReplyText)
+	default:
+	}
+	if !confirm.Ack {
+		return fmt.Errorf("publish to %s(%s) is nacked by broker", exchange, routingKey)
+	}
+	return nil
+}
+
+// declareRetryQueue 申请等待指定时间后死信回原队列的重试队列
+func (c *BaseConsumer) declareRetryQueue(delay time.Duration) (string, error) {
+	delayMs := delay.Milliseconds()
+	name := fmt.Sprintf("%s.retry.%d", c.queueName, delayMs)
+	if _, ok := c.retryQueues.Load(delayMs); ok {
+		return name, nil
+	}
+
+	channel, err := c.mqConn.GetConn().Channel()
+	if err != nil {
+		return "", err
+	}
+	defer channel.Close()
+
+	_, err = channel.QueueDeclare(
+		name,
+		true,
+		false,
+		false,
+		false,
+		amqp.Table{
+			"x-message-ttl":             delayMs,
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": c.queueName,
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+	c.retryQueues.Store(delayMs, struct{}{})
+	return name, nil
+}
+
+// deadLetterQueue 申请死信队列
+func (c *BaseConsumer) deadLetterQueue() (string, error) {
+	if c.deadLetterDeclared.Load() {
+		return DeadLetterQueueName(c.queueName), nil
+	}
+
+	channel, err := c.mqConn.GetConn().Channel()
+	if err != nil {
+		return "", err
+	}
+	defer channel.Close()
+
+	name, err := declareDeadLetterQueue(channel, c.queueName)
+	if err != nil {
+		return "", err
+	}
+	c.deadLetterDeclared.Store(true)
+	return name, nil
+}
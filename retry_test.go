@@ -0,0 +1,93 @@
+package rbmq
+
+import (
+	"errors"
+	"github.com/streadway/amqp"
+	"testing"
+)
+
+// fakeChannel 模拟开启了 confirm 模式的 channel，按 broker 的顺序先退回再确认
+type fakeChannel struct {
+	confirms   chan amqp.Confirmation
+	returns    chan amqp.Return
+	unroutable bool  // 消息无法路由，broker 退回后确认
+	nack       bool  // broker 拒绝消息
+	closed     bool  // 确认之前 channel 被关闭
+	publishErr error // Publish 直接返回的错误
+	published  []amqp.Publishing
+	deliveries []amqp.Delivery // Get 依次返回的消息
+}
+
+func newFakeChannel() *fakeChannel {
+	return &fakeChannel{
+		confirms: make(chan amqp.Confirmation, 1),
+		returns:  make(chan amqp.Return, 1),
+	}
+}
+
+func (c *fakeChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if c.publishErr != nil {
+		return c.publishErr
+	}
+	c.published = append(c.published, msg)
+	tag := uint64(len(c.published))
+	if c.closed {
+		close(c.confirms)
+		return nil
+	}
+	if c.unroutable && mandatory {
+		c.returns <- amqp.Return{ReplyCode: amqp.NoRoute, ReplyText: "NO_ROUTE", Exchange: exchange, RoutingKey: key}
+	}
+	c.confirms <- amqp.Confirmation{DeliveryTag: tag, Ack: !c.nack}
+	return nil
+}
+
+func (c *fakeChannel) Get(queue string, autoAck bool) (amqp.Delivery, bool, error) {
+	if len(c.deliveries) == 0 {
+		return amqp.Delivery{}, false, nil
+	}
+	d := c.deliveries[0]
+	c.deliveries = c.deliveries[1:]
+	return d, true, nil
+}
+
+func TestPublishMandatory(t *testing.T) {
+	publishErr := errors.New("channel closed")
+	tests := []struct {
+		name    string
+		setup   func(c *fakeChannel)
+		wantErr error // 为空时要求成功
+		anyErr  bool  // 只要求返回错误
+	}{
+		{name: "routed", setup: func(c *fakeChannel) {}},
+		{name: "unroutable", setup: func(c *fakeChannel) { c.unroutable = true }, wantErr: MessageUnroutable},
+		{name: "nacked", setup: func(c *fakeChannel) { c.nack = true }, anyErr: true},
+		{name: "publish error", setup: func(c *fakeChannel) { c.publishErr = publishErr }, wantErr: publishErr},
+		{name: "channel closed", setup: func(c *fakeChannel) { c.closed = true }, anyErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newFakeChannel()
+			tt.setup(c)
+			err := publishMandatory(c, c.confirms, c.returns, "", "q.retry.1000", amqp.Publishing{Body: []byte("x")})
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("publishMandatory = %v, want %v", err, tt.wantErr)
+				}
+			case tt.anyErr:
+				if err == nil {
+					t.Error("publishMandatory succeeded, want error")
+				}
+			default:
+				if err != nil {
+					t.Errorf("publishMandatory = %v, want nil", err)
+				}
+			}
+			// 每次发送后都不应该遗留退回的消息，否则会被算到下一条上
+			if len(c.returns) != 0 {
+				t.Errorf("%d returns left unread", len(c.returns))
+			}
+		})
+	}
+}
@@ -13,6 +13,7 @@ type RMQConn struct {
 	conn        atomic.Value // 连接(*amqp.Connection)
 	mqURL       string       // 连接信息(amqp://账号:密码@主机:端口号/虚拟主机)
 	normalClose atomic.Bool  // 是否是正常关闭
+	topology    topology     // 通过该连接申请的交换机和绑定关系，重连后恢复
 }
 
 // NewRMQConn 创建一个 RMQConn 实例
@@ -67,6 +68,8 @@ func (r *RMQConn) keepAlive() {
 			for {
 				newCon, err := amqp.Dial(r.mqURL)
 				if err == nil {
+					// 先恢复拓扑再替换连接，避免 publisher 在绑定关系恢复前发送的消息无法路由
+					r.topology.recover(newCon)
 					r.conn.Store(newCon)
 					log.Printf("keepAlive: auto-reconnect successfully！\n")
 					goto Loop
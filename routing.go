@@ -13,7 +13,7 @@ import (
 */
 
 type RoutingPublisher struct {
-	mqConn       *RMQConn // 连接
+	*BasePublisher
 	exchangeName string
 }
 
@@ -24,7 +24,8 @@ type RoutingPublisher struct {
 // routingKey：绑定路由
 // durable：持久化
 // autoDelete：自动删除
-func NewRoutingPublisher(conn *RMQConn, exchangeName string, durable, autoDelete bool) (*RoutingPublisher, error) {
+// opts：可选配置
+func NewRoutingPublisher(conn *RMQConn, exchangeName string, durable, autoDelete bool, opts ...PublisherOption) (*RoutingPublisher, error) {
 	if conn == nil {
 		return nil, ConnIsNil
 	}
@@ -33,8 +34,8 @@ func NewRoutingPublisher(conn *RMQConn, exchangeName string, durable, autoDelete
 	}
 
 	r := &RoutingPublisher{
-		mqConn:       conn,
-		exchangeName: exchangeName,
+		BasePublisher: NewBasePublisher(conn, opts...),
+		exchangeName:  exchangeName,
 	}
 	channel, err := r.mqConn.GetConn().Channel()
 	if err != nil {
@@ -42,15 +43,17 @@ func NewRoutingPublisher(conn *RMQConn, exchangeName string, durable, autoDelete
 	}
 	defer channel.Close()
 
+	kind, internal, exchangeArgs := r.opts.exchange.declareArgs("direct")
+
 	// 尝试创建交换机，不存在创建
 	err = channel.ExchangeDeclare(
 		r.exchangeName, //交换机名称
-		"direct",       //交换机类型完全匹配类型
+		kind,           //交换机类型，默认为完全匹配类型 direct
 		durable,        //是否持久化
 		autoDelete,     //是否字段删除
-		false,          //true 表示这个 exchange 不可以被 client 用来推送消息，仅用来进行 exchange 和 exchange 之间的绑定
+		internal,       //true 表示这个 exchange 不可以被 client 用来推送消息，仅用来进行 exchange 和 exchange 之间的绑定
 		false,          //是否阻塞 true 表示要等待服务器的响应
-		nil,
+		exchangeArgs,
 	)
 	if err != nil {
 		return nil, err
@@ -61,37 +64,39 @@ func NewRoutingPublisher(conn *RMQConn, exchangeName string, durable, autoDelete
 // Publish
 // message：消息内容
 // expirationSecond：过期时间，秒；0 表示永不过期
-func (r *RoutingPublisher) Publish(message []byte, routingKey string, expirationSecond uint64) (err error) {
+// opts：单条消息的可选配置
+func (r *RoutingPublisher) Publish(message []byte, routingKey string, expirationSecond uint64, opts ...PublishOption) (err error) {
 	if len(routingKey) == 0 {
 		return RoutingKeyIsRequired
 	}
-	channel, err := r.mqConn.GetConn().Channel()
-	if err != nil {
-		return err
-	}
-	defer channel.Close()
 	expiration := ""
 	if expirationSecond > 0 {
 		expiration = fmt.Sprintf("%d", expirationSecond*1000)
 	}
-	err = channel.Publish(
+	err = r.publish(
 		r.exchangeName,
 		routingKey,
-		false,
-		false,
 		amqp.Publishing{
 			Expiration:   expiration,      // 过期毫秒数
 			DeliveryMode: amqp.Persistent, // 持久化
 			ContentType:  "text/plain",
 			Body:         message,
 			Timestamp:    time.Now(),
-		})
+		}, opts...)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// PublishMessage 发送自定义属性的消息
+func (r *RoutingPublisher) PublishMessage(routingKey string, msg amqp.Publishing) error {
+	if len(routingKey) == 0 {
+		return RoutingKeyIsRequired
+	}
+	return r.publish(r.exchangeName, routingKey, msg)
+}
+
 type RoutingConsumer struct {
 	*BaseConsumer
 }
@@ -103,7 +108,8 @@ type RoutingConsumer struct {
 // routingKey：绑定路由,不能为空
 // durable：持久化
 // autoDelete：自动删除
-func NewRoutingConsumer(conn *RMQConn, exchangeName, queueName, routingKey string, durable, autoDelete bool) (IConsumer, error) {
+// opts：可选配置
+func NewRoutingConsumer(conn *RMQConn, exchangeName, queueName, routingKey string, durable, autoDelete bool, opts ...ConsumerOption) (IConsumer, error) {
 	if conn == nil {
 		return nil, ConnIsNil
 	}
@@ -121,15 +127,18 @@ func NewRoutingConsumer(conn *RMQConn, exchangeName, queueName, routingKey strin
 	}
 	defer channel.Close()
 
+	o := newConsumerOptions(opts)
+	kind, internal, exchangeArgs := o.exchange.declareArgs("direct")
+
 	// 1、尝试创建交换机，不存在创建
 	err = channel.ExchangeDeclare(
 		exchangeName, //交换机名称
-		"direct",     //交换机类型完全匹配类型
+		kind,         //交换机类型，默认为完全匹配类型 direct
 		durable,      //是否持久化
 		autoDelete,   //是否字段删除
-		false,        //true 表示这个 exchange 不可以被 client 用来推送消息，仅用来进行 exchange 和 exchange 之间的绑定
+		internal,     //true 表示这个 exchange 不可以被 client 用来推送消息，仅用来进行 exchange 和 exchange 之间的绑定
 		false,        //是否阻塞 true 表示要等待服务器的响应
-		nil,
+		exchangeArgs,
 	)
 
 	if err != nil {
@@ -142,6 +151,22 @@ func NewRoutingConsumer(conn *RMQConn, exchangeName, queueName, routingKey strin
 		autoDelete = true
 	}
 
+	queueArgs, err := o.queue.arguments(durable, autoDelete)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = o.checkRetry(queueName); err != nil {
+		return nil, err
+	}
+
+	if o.deadLetter {
+		queueArgs, err = deadLetterArguments(channel, queueName, queueArgs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	//2、 试探性创建队列
 	q, err := channel.QueueDeclare(
 		queueName,  // 队列名字
@@ -149,7 +174,7 @@ func NewRoutingConsumer(conn *RMQConn, exchangeName, queueName, routingKey strin
 		autoDelete, // 自动删除
 		false,      // exclusive 独占队列只能由声明它们的连接访问，并且连接关闭时将被删除。当前其他连接上的通道尝试声明、绑定、消费、清除或删除同名队列时将反回一个错误。
 		false,      // no-wait
-		nil,        // arguments
+		queueArgs,  // arguments
 	)
 	if err != nil {
 		return nil, err
@@ -167,6 +192,6 @@ func NewRoutingConsumer(conn *RMQConn, exchangeName, queueName, routingKey strin
 	}
 
 	c := &RoutingConsumer{}
-	c.BaseConsumer = NewBaseConsumer(conn, DefaultPrefetchCount, q.Name, c)
+	c.BaseConsumer = NewBaseConsumer(conn, DefaultPrefetchCount, q.Name, c, opts...)
 	return c, nil
 }
@@ -0,0 +1,377 @@
+package rbmq
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/streadway/amqp"
+	"log"
+	"sync"
+	"time"
+)
+
+/*
+7 RPC 模式，客户端发送请求后等待服务端的响应
+
+	应用场景: 需要同步拿到处理结果的远程调用
+
+客户端接收响应的方式有两种，通过 RPCReplyMode 选择：
+(1) RPCReplyDirect：使用 rabbitmq 的 direct reply-to 特性（amq.rabbitmq.reply-to 伪队列），不需要创建回复队列，性能最好。
+(2) RPCReplyQueue：客户端申请一个独占、自动删除的私有回复队列，通过 CorrelationId 匹配请求与响应。
+*/
+
+type RPCReplyMode int
+
+const (
+	RPCReplyDirect RPCReplyMode = iota // direct reply-to
+	RPCReplyQueue                      // 私有回复队列
+)
+
+const (
+	DefaultRPCTimeout = 30 * time.Second
+
+	directReplyQueue = "amq.rabbitmq.reply-to"
+	rpcErrorHeader   = "x-rpc-error" // 服务端处理失败时，错误信息通过该头返回给客户端
+)
+
+// RPCError 服务端处理函数返回的错误
+type RPCError struct {
+	Message string
+}
+
+func (e *RPCError) Error() string {
+	return "rpc: " + e.Message
+}
+
+type rpcReply struct {
+	delivery amqp.Delivery
+	err      error
+}
+
+type RPCClient struct {
+	mqConn       *RMQConn // 连接
+	exchangeName string
+	mode         RPCReplyMode
+	timeout      time.Duration
+
+	mu         sync.Mutex    // 保护 channel、replyQueue
+	channel    *amqp.Channel // 发送请求和接收响应共用一个 channel，direct reply-to 要求必须在同一个 channel 上
+	replyQueue string
+
+	pendingMu sync.Mutex
+	pending   map[string]chan rpcReply // CorrelationId -> 等待响应的调用
+}
+
+// NewRPCClient 创建 RPC 客户端
+// conn：rabbit mq 连接
+// exchangeName：请求发送到的交换机，可为空，为空时使用默认交换机，此时 Call 的路由 key 即为服务端的队列名
+// mode：接收响应的方式
+// timeout：调用超时时间，ctx 没有设置超时时生效，小于等于 0 时使用 DefaultRPCTimeout
+func NewRPCClient(conn *RMQConn, exchangeName string, mode RPCReplyMode, timeout time.Duration) (*RPCClient, error) {
+	if conn == nil {
+		return nil, ConnIsNil
+	}
+	if mode != RPCReplyDirect && mode != RPCReplyQueue {
+		return nil, RPCReplyModeUnknown
+	}
+	if timeout <= 0 {
+		timeout = DefaultRPCTimeout
+	}
+	r := &RPCClient{
+		mqConn:       conn,
+		exchangeName: exchangeName,
+		mode:         mode,
+		timeout:      timeout,
+		pending:      make(map[string]chan rpcReply),
+	}
+	// 提前准备好 channel，尽早暴露连接问题
+	if _, _, err := r.prepare(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Call 发送请求并等待响应
+// ctx：控制超时和取消，没有设置超时时使用客户端的默认超时时间
+// routingKey：路由 key，使用默认交换机时为服务端的队列名
+// body：请求内容
+func (r *RPCClient) Call(ctx context.Context, routingKey string, body []byte) ([]byte, error) {
+	if len(routingKey) == 0 {
+		return nil, RoutingKeyIsRequired
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	channel, replyTo, err := r.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	correlationId, err := newCorrelationId()
+	if err != nil {
+		return nil, err
+	}
+	replyChan := make(chan rpcReply, 1)
+	r.pendingMu.Lock()
+	r.pending[correlationId] = replyChan
+	r.pendingMu.Unlock()
+	defer func() {
+		r.pendingMu.Lock()
+		delete(r.pending, correlationId)
+		r.pendingMu.Unlock()
+	}()
+
+	msg := amqp.Publishing{
+		ContentType:   "text/plain",
+		CorrelationId: correlationId,
+		ReplyTo:       replyTo,
+		Body:          body,
+		Timestamp:     time.Now(),
+	}
+	// 请求在调用超时后已经没有意义，让 broker 丢弃过期的请求
+	if deadline, ok := ctx.Deadline(); ok {
+		if ms := time.Until(deadline).Milliseconds(); ms > 0 {
+			msg.Expiration = fmt.Sprintf("%d", ms)
+		}
+	}
+	err = channel.Publish(
+		r.exchangeName,
+		routingKey,
+		true, // mandatory 没有队列可以路由时，消息会被退回，调用快速失败
+		false,
+		msg,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case reply := <-replyChan:
+		if reply.err != nil {
+			return nil, reply.err
+		}
+		if errMsg, ok := reply.delivery.Headers[rpcErrorHeader].(string); ok {
+			return nil, &RPCError{Message: errMsg}
+		}
+		return reply.delivery.Body, nil
+	}
+}
+
+// Close 关闭客户端，注意不会关闭连接，因为连接可能不是独占的
+func (r *RPCClient) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.channel == nil {
+		return nil
+	}
+	err := r.channel.Close()
+	r.channel = nil
+	return err
+}
+
+// prepare 返回可用的 channel 和回复地址，channel 不存在或已经关闭（如断网重连）时重新创建
+func (r *RPCClient) prepare() (*amqp.Channel, string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.channel != nil {
+		return r.channel, r.replyQueue, nil
+	}
+
+	channel, err := r.mqConn.GetConn().Channel()
+	if err != nil {
+		return nil, "", err
+	}
+	replyQueue := directReplyQueue
+	if r.mode == RPCReplyQueue {
+		q, err := channel.QueueDeclare(
+			"",    // 队列名，不填则随机生成一个
+			false, // 是否持久化队列
+			true,  // 自动删除
+			true,  // 独占队列，连接断开时自动删除
+			false,
+			nil,
+		)
+		if err != nil {
+			channel.Close()
+			return nil, "", err
+		}
+		replyQueue = q.Name
+	}
+
+	// direct reply-to 要求必须是自动确认模式
+	deliveryChan, err := channel.Consume(
+		replyQueue,
+		"",
+		true,
+		r.mode == RPCReplyQueue, // exclusive
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		channel.Close()
+		return nil, "", err
+	}
+	returnChan := channel.NotifyReturn(make(chan amqp.Return, 1))
+
+	r.channel = channel
+	r.replyQueue = replyQueue
+	go r.receive(channel, deliveryChan, returnChan)
+	return channel, replyQueue, nil
+}
+
+// receive 分发响应和被退回的请求，channel 关闭后让所有等待中的调用失败
+func (r *RPCClient) receive(channel *amqp.Channel, deliveryChan <-chan amqp.Delivery, returnChan <-chan amqp.Return) {
+	for deliveryChan != nil || returnChan != nil {
+		select {
+		case d, ok := <-deliveryChan:
+			if !ok {
+				deliveryChan = nil
+				continue
+			}
+			r.reply(d.CorrelationId, rpcReply{delivery: d})
+		case ret, ok := <-returnChan:
+			if !ok {
+				returnChan = nil
+				continue
+			}
+			r.reply(ret.CorrelationId, rpcReply{err: RPCNoRoute})
+		}
+	}
+
+	log.Println("RPCClient: reply channel closed！")
+	r.mu.Lock()
+	if r.channel == channel {
+		r.channel = nil
+	}
+	r.mu.Unlock()
+
+	// 旧 channel 上的回复已经收不到了
+	r.pendingMu.Lock()
+	for correlationId, replyChan := range r.pending {
+		replyChan <- rpcReply{err: RPCChannelClosed}
+		delete(r.pending, correlationId)
+	}
+	r.pendingMu.Unlock()
+}
+
+func (r *RPCClient) reply(correlationId string, reply rpcReply) {
+	r.pendingMu.Lock()
+	defer r.pendingMu.Unlock()
+	replyChan, ok := r.pending[correlationId]
+	if !ok {
+		// 调用已经超时或取消
+		return
+	}
+	delete(r.pending, correlationId)
+	replyChan <- reply
+}
+
+// RPCHandler 服务端处理函数，返回值会作为响应发送给客户端，返回的错误会通过 RPCError 传给客户端
+type RPCHandler func(payload []byte) ([]byte, error)
+
+// RPCMessageHandler 与 RPCHandler 相同，可以拿到请求的元数据
+type RPCMessageHandler func(ctx context.Context, msg *Message) ([]byte, error)
+
+type RPCServer struct {
+	*BaseConsumer
+}
+
+// NewRPCServer 创建 RPC 服务端
+// conn：rabbit mq 连接
+// queueName：请求队列名，不能为空，客户端使用默认交换机时以该队列名作为路由 key
+// durable：持久化
+// autoDelete：自动删除
+// opts：可选配置
+func NewRPCServer(conn *RMQConn, queueName string, durable, autoDelete bool, opts ...ConsumerOption) (*RPCServer, error) {
+	if conn == nil {
+		return nil, ConnIsNil
+	}
+	if queueName == "" {
+		return nil, QueueNameIsEmpty
+	}
+	channel, err := conn.GetConn().Channel()
+	if err != nil {
+		return nil, err
+	}
+	defer channel.Close()
+
+	o := newConsumerOptions(opts)
+	queueArgs, err := o.queue.arguments(durable, autoDelete)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.deadLetter {
+		queueArgs, err = deadLetterArguments(channel, queueName, queueArgs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 申请请求队列,如果队列不存在则创建,存在则跳过
+	q, err := channel.QueueDeclare(
+		queueName,
+		durable,
+		autoDelete,
+		false,
+		false,
+		queueArgs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	s := &RPCServer{}
+	s.BaseConsumer = NewBaseConsumer(conn, DefaultPrefetchCount, q.Name, s, opts...)
+	return s, nil
+}
+
+// Serve 监听请求并把处理结果发送到请求的 ReplyTo，该方法会阻塞调用，建议开启一个单独的 goroutine 调用
+func (s *RPCServer) Serve(handler RPCHandler) error {
+	return s.ServeMessage(func(ctx context.Context, msg *Message) ([]byte, error) {
+		return handler(msg.Body)
+	})
+}
+
+// ServeMessage 与 Serve 相同，处理函数可以拿到请求的元数据
+func (s *RPCServer) ServeMessage(handler RPCMessageHandler) error {
+	return s.ConsumeMessage(func(ctx context.Context, msg *Message) error {
+		result, err := handler(ctx, msg)
+		if msg.ReplyTo == "" {
+			// 没有回复地址，客户端不需要响应
+			log.Printf("RPCServer: request %s has no reply-to, response dropped\n", msg.CorrelationId)
+			return nil
+		}
+		reply := amqp.Publishing{
+			ContentType:   "text/plain",
+			CorrelationId: msg.CorrelationId,
+			Body:          result,
+			Timestamp:     time.Now(),
+		}
+		if err != nil {
+			reply.Headers = amqp.Table{rpcErrorHeader: err.Error()}
+		}
+
+		channel, err := s.mqConn.GetConn().Channel()
+		if err != nil {
+			return err
+		}
+		defer channel.Close()
+		// 响应发送失败时返回错误，请求会重新入队
+		return channel.Publish("", msg.ReplyTo, false, false, reply)
+	})
+}
+
+func newCorrelationId() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,249 @@
+package rbmq
+
+import (
+	"fmt"
+	"github.com/streadway/amqp"
+	"hash/fnv"
+	"strconv"
+	"time"
+)
+
+/*
+9 Sharded 分片模式，消息按 key 的哈希分散到 N 个分片队列，相同 key 的消息总是进入同一个队列
+
+	应用场景: 需要按 key 保序处理（例如同一个用户、同一个订单的消息），同时又要水平扩展消费能力
+
+分片的实现方式有两种，通过 ShardMode 选择：
+(1) ShardModeConsistentHash：依赖 rabbitmq_consistent_hash_exchange 插件，交换机类型为 x-consistent-hash，
+由 broker 对路由 key 做一致性哈希，队列绑定时的 key 为权重。增减分片时只有少部分 key 会换到别的队列。
+(2) ShardModeClientHash：publisher 对 key 做 fnv 哈希后对 N 取模，以分片序号作为路由 key 发送到 direct 交换机，
+不需要插件，但 publisher 与 consumer 的分片数必须一致，修改分片数后大部分 key 会换到别的队列。
+
+每个分片队列只有一个 consumer 且串行处理时，同一个 key 的消息严格按发送顺序处理。
+*/
+
+type ShardMode int
+
+const (
+	ShardModeConsistentHash ShardMode = iota // x-consistent-hash 插件
+	ShardModeClientHash                      // client 端哈希取模
+)
+
+const consistentHashExchangeKind = "x-consistent-hash"
+
+type ShardedPublisher struct {
+	*BasePublisher
+	exchangeName string
+	shards       int
+	mode         ShardMode
+}
+
+// NewShardedPublisher 创建分片模式下的 publisher
+// conn：rabbit mq 连接
+// exchangeName：不能为空
+// shards：分片数，必须大于 0，ShardModeClientHash 模式下需要与 consumer 一致
+// mode：分片的实现方式
+// durable：持久化
+// autoDelete：自动删除
+// opts：可选配置
+func NewShardedPublisher(conn *RMQConn, exchangeName string, shards int, mode ShardMode, durable, autoDelete bool, opts ...PublisherOption) (*ShardedPublisher, error) {
+	if conn == nil {
+		return nil, ConnIsNil
+	}
+	if exchangeName == "" {
+		return nil, ExchangeNameIsEmpty
+	}
+	if shards <= 0 {
+		return nil, ShardCountInvalid
+	}
+	r := &ShardedPublisher{
+		BasePublisher: NewBasePublisher(conn, opts...),
+		exchangeName:  exchangeName,
+		shards:        shards,
+		mode:          mode,
+	}
+	channel, err := r.mqConn.GetConn().Channel()
+	if err != nil {
+		return nil, err
+	}
+	defer channel.Close()
+
+	// 尝试创建交换机，不存在创建
+	err = declareShardExchange(channel, exchangeName, durable, autoDelete, mode, r.opts.exchange)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Publish
+// message：消息内容
+// shardKey：分片 key，不能为空，相同 key 的消息进入同一个分片
+// expirationSecond：过期时间，秒；0 表示永不过期
+// opts：单条消息的可选配置
+func (r *ShardedPublisher) Publish(message []byte, shardKey string, expirationSecond uint64, opts ...PublishOption) error {
+	expiration := ""
+	if expirationSecond > 0 {
+		expiration = fmt.Sprintf("%d", expirationSecond*1000)
+	}
+	return r.publishShard(shardKey, amqp.Publishing{
+		Expiration:   expiration,      // 过期毫秒数
+		DeliveryMode: amqp.Persistent, // 持久化
+		ContentType:  "text/plain",
+		Body:         message,
+		Timestamp:    time.Now(),
+	}, opts...)
+}
+
+// PublishMessage 发送自定义属性的消息，routingKey 为分片 key
+func (r *ShardedPublisher) PublishMessage(routingKey string, msg amqp.Publishing) error {
+	return r.publishShard(routingKey, msg)
+}
+
+func (r *ShardedPublisher) publishShard(routingKey string, msg amqp.Publishing, opts ...PublishOption) error {
+	if len(routingKey) == 0 {
+		return RoutingKeyIsRequired
+	}
+	switch r.mode {
+	case ShardModeConsistentHash:
+		// broker 对路由 key 做一致性哈希
+		return r.publish(r.exchangeName, routingKey, msg, opts...)
+	case ShardModeClientHash:
+		return r.publish(r.exchangeName, shardRoutingKey(r.Shard(routingKey)), msg, opts...)
+	default:
+		return ShardModeUnknown
+	}
+}
+
+// Shard 返回分片 key 在 ShardModeClientHash 模式下对应的分片序号，ShardModeConsistentHash 模式下由 broker 决定，仅供参考
+func (r *ShardedPublisher) Shard(shardKey string) int {
+	h := fnv.New32a()
+	h.Write([]byte(shardKey))
+	return int(h.Sum32() % uint32(r.shards))
+}
+
+type ShardConsumer struct {
+	*BaseConsumer
+	shard int
+}
+
+// Shard 返回 consumer 监听的分片序号
+func (c *ShardConsumer) Shard() int {
+	return c.shard
+}
+
+// NewShardConsumers 申请交换机和 N 个分片队列并绑定，每个分片队列创建一个 consumer，返回的 consumer 按分片序号排列
+// conn：rabbit mq 连接
+// exchangeName：不能为空
+// queuePrefix：分片队列名前缀，不能为空，分片队列名为 <queuePrefix>.<分片序号>
+// shards：分片数，必须大于 0，ShardModeClientHash 模式下需要与 publisher 一致
+// mode：分片的实现方式，需要与 publisher 一致
+// durable：持久化
+// autoDelete：自动删除
+// opts：可选配置，对每个分片的 consumer 生效
+func NewShardConsumers(conn *RMQConn, exchangeName, queuePrefix string, shards int, mode ShardMode, durable, autoDelete bool, opts ...ConsumerOption) ([]*ShardConsumer, error) {
+	if conn == nil {
+		return nil, ConnIsNil
+	}
+	if exchangeName == "" {
+		return nil, ExchangeNameIsEmpty
+	}
+	if queuePrefix == "" {
+		return nil, QueueNameIsEmpty
+	}
+	if shards <= 0 {
+		return nil, ShardCountInvalid
+	}
+
+	channel, err := conn.GetConn().Channel()
+	if err != nil {
+		return nil, err
+	}
+	defer channel.Close()
+
+	o := newConsumerOptions(opts)
+	// 1、尝试创建交换机，不存在创建
+	err = declareShardExchange(channel, exchangeName, durable, autoDelete, mode, o.exchange)
+	if err != nil {
+		return nil, err
+	}
+
+	queueArgs, err := o.queue.arguments(durable, autoDelete)
+	if err != nil {
+		return nil, err
+	}
+
+	consumers := make([]*ShardConsumer, 0, shards)
+	for i := 0; i < shards; i++ {
+		name := fmt.Sprintf("%s.%d", queuePrefix, i)
+		shardArgs := queueArgs
+		if o.deadLetter {
+			shardArgs, err = deadLetterArguments(channel, name, queueArgs)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// 2、试探性创建分片队列
+		q, err := channel.QueueDeclare(
+			name,
+			durable,
+			autoDelete,
+			false,
+			false,
+			shardArgs,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		// 3、绑定分片队列到 exchange 中，一致性哈希模式下 key 为权重，所有分片权重相同
+		bindingKey := "1"
+		if mode == ShardModeClientHash {
+			bindingKey = shardRoutingKey(i)
+		}
+		err = channel.QueueBind(
+			q.Name,
+			bindingKey,
+			exchangeName,
+			false,
+			nil,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		c := &ShardConsumer{shard: i}
+		c.BaseConsumer = NewBaseConsumer(conn, DefaultPrefetchCount, q.Name, c, opts...)
+		consumers = append(consumers, c)
+	}
+	return consumers, nil
+}
+
+// declareShardExchange 申请分片交换机
+func declareShardExchange(channel *amqp.Channel, exchangeName string, durable, autoDelete bool, mode ShardMode, o *ExchangeOptions) error {
+	var kind string
+	switch mode {
+	case ShardModeConsistentHash:
+		kind = consistentHashExchangeKind
+	case ShardModeClientHash:
+		kind = amqp.ExchangeDirect
+	default:
+		return ShardModeUnknown
+	}
+	kind, internal, args := o.declareArgs(kind)
+	return channel.ExchangeDeclare(
+		exchangeName,
+		kind,
+		durable,
+		autoDelete,
+		internal,
+		false,
+		args,
+	)
+}
+
+// shardRoutingKey ShardModeClientHash 模式下分片序号对应的路由 key
+func shardRoutingKey(shard int) string {
+	return strconv.Itoa(shard)
+}
@@ -0,0 +1,359 @@
+package rbmq
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/streadway/amqp"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+关于消息签名
+publisher 通过 WithSigning 对消息体和指定的消息头签名，签名、算法、密钥 ID 和参与签名的消息头名写入消息头。
+consumer 通过 WithSignatureVerification 校验签名，没有签名或签名不正确的消息直接拒绝，不会重新入队，
+队列配置了死信时转入死信，不会交给处理函数。
+
+签名在压缩、加密之后进行，签的是实际发送的内容，consumer 先验签再解密、解压。
+
+参与签名的消息头名列表和消息体长度本身也参与签名，修改消息头名列表或者把消息头挪进消息体都会导致验签失败。
+消息头的值按 AMQP 字段类型编码后签名，与 consumer 从网络上解码出来的值编码一致：int 按 32 位编码，time.Time 只保留 Unix 秒，
+不支持的类型（例如 uint32）在发送时返回错误。
+但消息头名列表由 publisher 决定，依赖某个消息头的 consumer 需要在 WithSignatureVerification 中把它指定为必须签名的消息头，
+否则没有签名该消息头的消息（例如其他 publisher 发送的）也能通过验签。
+*/
+
+const (
+	signatureHeader        = "x-signature"
+	signatureAlgHeader     = "x-signature-alg"
+	signatureKeyIdHeader   = "x-signature-key-id"
+	signatureHeadersHeader = "x-signature-headers" // 参与签名的消息头名，逗号分隔
+
+	SignatureHMACSHA256 = "hmac-sha256"
+	SignatureEd25519    = "ed25519"
+)
+
+// Signer 消息签名
+type Signer interface {
+	Algorithm() string
+	KeyId() string // 密钥 ID，consumer 根据它查找验签的密钥
+	Sign(data []byte) ([]byte, error)
+}
+
+// Verifier 消息验签，签名不正确时返回错误
+type Verifier interface {
+	Verify(algorithm, keyId string, data, signature []byte) error
+}
+
+type hmacSigner struct {
+	keyId string
+	key   []byte
+}
+
+// NewHMACSigner 创建 HMAC-SHA256 签名，publisher 与 consumer 共享同一个密钥
+func NewHMACSigner(keyId string, key []byte) Signer {
+	return &hmacSigner{keyId: keyId, key: key}
+}
+
+func (s *hmacSigner) Algorithm() string {
+	return SignatureHMACSHA256
+}
+
+func (s *hmacSigner) KeyId() string {
+	return s.keyId
+}
+
+func (s *hmacSigner) Sign(data []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+type ed25519Signer struct {
+	keyId string
+	key   ed25519.PrivateKey
+}
+
+// NewEd25519Signer 创建 Ed25519 签名，publisher 持有私钥，consumer 只需要公钥
+func NewEd25519Signer(keyId string, key ed25519.PrivateKey) Signer {
+	return &ed25519Signer{keyId: keyId, key: key}
+}
+
+func (s *ed25519Signer) Algorithm() string {
+	return SignatureEd25519
+}
+
+func (s *ed25519Signer) KeyId() string {
+	return s.keyId
+}
+
+func (s *ed25519Signer) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(s.key, data), nil
+}
+
+type hmacVerifier struct {
+	keys map[string][]byte
+}
+
+// NewHMACVerifier 创建 HMAC-SHA256 验签
+// keys：受信任的密钥 ID -> 密钥
+func NewHMACVerifier(keys map[string][]byte) Verifier {
+	return &hmacVerifier{keys: keys}
+}
+
+func (v *hmacVerifier) Verify(algorithm, keyId string, data, signature []byte) error {
+	if algorithm != SignatureHMACSHA256 {
+		return fmt.Errorf("%w: unexpected algorithm %s", SignatureInvalid, algorithm)
+	}
+	key, ok := v.keys[keyId]
+	if !ok {
+		return fmt.Errorf("%w: untrusted key %s", SignatureInvalid, keyId)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		return SignatureInvalid
+	}
+	return nil
+}
+
+type ed25519Verifier struct {
+	keys map[string]ed25519.PublicKey
+}
+
+// NewEd25519Verifier 创建 Ed25519 验签
+// keys：受信任的密钥 ID -> 公钥
+func NewEd25519Verifier(keys map[string]ed25519.PublicKey) Verifier {
+	return &ed25519Verifier{keys: keys}
+}
+
+func (v *ed25519Verifier) Verify(algorithm, keyId string, data, signature []byte) error {
+	if algorithm != SignatureEd25519 {
+		return fmt.Errorf("%w: unexpected algorithm %s", SignatureInvalid, algorithm)
+	}
+	key, ok := v.keys[keyId]
+	if !ok {
+		return fmt.Errorf("%w: untrusted key %s", SignatureInvalid, keyId)
+	}
+	if !ed25519.Verify(key, data, signature) {
+		return SignatureInvalid
+	}
+	return nil
+}
+
+type signOptions struct {
+	signer  Signer
+	headers []string // 参与签名的消息头名
+}
+
+type verifyOptions struct {
+	verifier Verifier
+	required []string // 必须参与签名的消息头名
+}
+
+// WithSigning 给 publisher 开启签名
+// s：签名方式
+// headers：除消息体外参与签名的消息头名
+func WithSigning(s Signer, headers ...string) PublisherOption {
+	names := append([]string(nil), headers...)
+	sort.Strings(names)
+	return publisherOptionFunc(func(o *publisherOptions) {
+		o.sign = &signOptions{
+			signer:  s,
+			headers: names,
+		}
+	})
+}
+
+// WithSignatureVerification 给 consumer 开启验签，没有签名或签名不正确的消息会被拒绝
+// v：验签方式
+// requiredHeaders：必须参与签名的消息头名，需要是 publisher WithSigning 中 headers 的子集
+func WithSignatureVerification(v Verifier, requiredHeaders ...string) ConsumerOption {
+	required := append([]string(nil), requiredHeaders...)
+	return consumerOptionFunc(func(o *consumerOptions) {
+		o.verify = &verifyOptions{
+			verifier: v,
+			required: required,
+		}
+	})
+}
+
+// sign 对消息签名，并把签名信息写入消息头
+func (o *signOptions) sign(msg *amqp.Publishing) error {
+	data, err := signingData(msg.Body, msg.Headers, o.headers)
+	if err != nil {
+		return err
+	}
+	signature, err := o.signer.Sign(data)
+	if err != nil {
+		return err
+	}
+	headers := make(amqp.Table, len(msg.Headers)+4)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[signatureHeader] = signature
+	headers[signatureAlgHeader] = o.signer.Algorithm()
+	headers[signatureKeyIdHeader] = o.signer.KeyId()
+	headers[signatureHeadersHeader] = strings.Join(o.headers, ",")
+	msg.Headers = headers
+	return nil
+}
+
+// verifyDelivery 校验消息签名，通过后移除签名相关的消息头
+func (o *verifyOptions) verifyDelivery(d *amqp.Delivery) error {
+	if o == nil {
+		return nil
+	}
+	signature, ok := d.Headers[signatureHeader].([]byte)
+	if !ok {
+		return SignatureMissing
+	}
+	algorithm, _ := d.Headers[signatureAlgHeader].(string)
+	keyId, _ := d.Headers[signatureKeyIdHeader].(string)
+	var names []string
+	if s, _ := d.Headers[signatureHeadersHeader].(string); s != "" {
+		names = strings.Split(s, ",")
+	}
+	for _, name := range o.required {
+		if !containsString(names, name) {
+			return fmt.Errorf("%w: header %s is not signed", SignatureInvalid, name)
+		}
+	}
+	data, err := signingData(d.Body, d.Headers, names)
+	if err != nil {
+		return fmt.Errorf("%w: %s", SignatureInvalid, err)
+	}
+	if err = o.verifier.Verify(algorithm, keyId, data, signature); err != nil {
+		return err
+	}
+	delete(d.Headers, signatureHeader)
+	delete(d.Headers, signatureAlgHeader)
+	delete(d.Headers, signatureKeyIdHeader)
+	delete(d.Headers, signatureHeadersHeader)
+	return nil
+}
+
+// signingData 生成待签名的数据，所有部分都带长度前缀，避免拼接产生歧义：
+// 先写消息头的个数，每个消息头写入 "名字长度:名字" 和编码后的值，不存在的消息头的值为一个 0 字节，最后是 "消息体长度:消息体"
+func signingData(body []byte, headers amqp.Table, names []string) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d:", len(names))
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%d:%s", len(name), name)
+		v, ok := headers[name]
+		if !ok {
+			buf.WriteByte(0)
+			continue
+		}
+		if err := writeSigningField(&buf, v); err != nil {
+			return nil, fmt.Errorf("sign header %s: %w", name, err)
+		}
+	}
+	fmt.Fprintf(&buf, "%d:", len(body))
+	buf.Write(body)
+	return buf.Bytes(), nil
+}
+
+// writeSigningField 按 AMQP 字段类型编码消息头的值：类型标记加大端序的值，变长的值带长度前缀，
+// 编码与经过 broker 传输后解码出来的值一致，例如 int 传输后为 int32，byte 可能被解码为 int8，time.Time 只保留 Unix 秒
+func writeSigningField(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteByte('V')
+	case bool:
+		buf.WriteByte('t')
+		if v {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case byte:
+		buf.WriteByte('b')
+		buf.WriteByte(v)
+	case int8:
+		buf.WriteByte('b')
+		buf.WriteByte(byte(v))
+	case int16:
+		buf.WriteByte('s')
+		writeUint(buf, uint16(v))
+	case int:
+		buf.WriteByte('I')
+		writeUint(buf, uint32(v))
+	case int32:
+		buf.WriteByte('I')
+		writeUint(buf, uint32(v))
+	case int64:
+		buf.WriteByte('l')
+		writeUint(buf, uint64(v))
+	case float32:
+		buf.WriteByte('f')
+		writeUint(buf, math.Float32bits(v))
+	case float64:
+		buf.WriteByte('d')
+		writeUint(buf, math.Float64bits(v))
+	case amqp.Decimal:
+		buf.WriteByte('D')
+		buf.WriteByte(v.Scale)
+		writeUint(buf, uint32(v.Value))
+	case string:
+		buf.WriteByte('S')
+		writeUint(buf, uint32(len(v)))
+		buf.WriteString(v)
+	case []byte:
+		buf.WriteByte('x')
+		writeUint(buf, uint32(len(v)))
+		buf.Write(v)
+	case time.Time:
+		buf.WriteByte('T')
+		writeUint(buf, uint64(v.Unix()))
+	case []interface{}:
+		buf.WriteByte('A')
+		writeUint(buf, uint32(len(v)))
+		for _, item := range v {
+			if err := writeSigningField(buf, item); err != nil {
+				return err
+			}
+		}
+	case amqp.Table:
+		// 传输后的 Table 是 map，没有顺序，按名字排序
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('F')
+		writeUint(buf, uint32(len(keys)))
+		for _, k := range keys {
+			writeUint(buf, uint32(len(k)))
+			buf.WriteString(k)
+			if err := writeSigningField(buf, v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported header type %T", value)
+	}
+	return nil
+}
+
+// writeUint 以大端序写入定长的无符号整数
+func writeUint(buf *bytes.Buffer, v interface{}) {
+	// 写入 bytes.Buffer 不会失败
+	_ = binary.Write(buf, binary.BigEndian, v)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
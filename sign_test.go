@@ -0,0 +1,153 @@
+package rbmq
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"github.com/streadway/amqp"
+	"testing"
+	"time"
+)
+
+// signToDelivery 签名消息，并转换成 consumer 收到的 delivery
+func signToDelivery(t *testing.T, o *signOptions, msg amqp.Publishing) *amqp.Delivery {
+	t.Helper()
+	if err := o.sign(&msg); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	headers := make(amqp.Table, len(msg.Headers))
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	return &amqp.Delivery{Headers: headers, Body: append([]byte(nil), msg.Body...)}
+}
+
+func testSigners(t *testing.T) map[string]struct {
+	signer   Signer
+	verifier Verifier
+} {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmacKey := []byte("0123456789abcdef")
+	return map[string]struct {
+		signer   Signer
+		verifier Verifier
+	}{
+		SignatureHMACSHA256: {NewHMACSigner("k1", hmacKey), NewHMACVerifier(map[string][]byte{"k1": hmacKey})},
+		SignatureEd25519:    {NewEd25519Signer("k1", priv), NewEd25519Verifier(map[string]ed25519.PublicKey{"k1": pub})},
+	}
+}
+
+func TestSignRoundTrip(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		sent     interface{} // publisher 设置的值
+		received interface{} // consumer 从网络上解码出来的值
+	}{
+		{name: "string", sent: "abc", received: "abc"},
+		{name: "bool", sent: true, received: true},
+		{name: "byte", sent: byte(7), received: byte(7)},
+		{name: "byte above 127", sent: byte(200), received: int8(-56)},
+		{name: "int16", sent: int16(-3), received: int16(-3)},
+		{name: "int", sent: 42, received: int32(42)},
+		{name: "int32", sent: int32(-42), received: int32(-42)},
+		{name: "int64", sent: int64(1) << 40, received: int64(1) << 40},
+		{name: "float32", sent: float32(1.5), received: float32(1.5)},
+		{name: "float64", sent: 0.1, received: 0.1},
+		{name: "decimal", sent: amqp.Decimal{Scale: 2, Value: 12345}, received: amqp.Decimal{Scale: 2, Value: 12345}},
+		{name: "bytes", sent: []byte{0, 1, 2}, received: []byte{0, 1, 2}},
+		{name: "nil", sent: nil, received: nil},
+		{name: "time", sent: now, received: time.Unix(now.Unix(), 0).In(time.FixedZone("UTC+8", 8*3600))},
+		{name: "array", sent: []interface{}{"a", 1, now}, received: []interface{}{"a", int32(1), time.Unix(now.Unix(), 0)}},
+		{
+			name:     "table",
+			sent:     amqp.Table{"a": 1, "b": "x", "c": amqp.Table{"d": byte(255)}},
+			received: amqp.Table{"c": amqp.Table{"d": int8(-1)}, "b": "x", "a": int32(1)},
+		},
+	}
+	for alg, s := range testSigners(t) {
+		for _, tt := range tests {
+			t.Run(alg+"/"+tt.name, func(t *testing.T) {
+				o := &signOptions{signer: s.signer, headers: []string{"h", "trace-id"}}
+				d := signToDelivery(t, o, amqp.Publishing{
+					Headers: amqp.Table{"h": tt.sent, "trace-id": "t1", "unsigned": "u"},
+					Body:    []byte("hello"),
+				})
+				d.Headers["h"] = tt.received
+				v := &verifyOptions{verifier: s.verifier, required: []string{"h"}}
+				if err := v.verifyDelivery(d); err != nil {
+					t.Fatalf("verifyDelivery: %v", err)
+				}
+				for _, h := range []string{signatureHeader, signatureAlgHeader, signatureKeyIdHeader, signatureHeadersHeader} {
+					if _, ok := d.Headers[h]; ok {
+						t.Errorf("header %s not removed", h)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestVerifyDeliveryTampered(t *testing.T) {
+	tests := []struct {
+		name     string
+		required []string
+		tamper   func(d *amqp.Delivery)
+		wantErr  error
+	}{
+		{name: "body", tamper: func(d *amqp.Delivery) { d.Body[0] ^= 1 }, wantErr: SignatureInvalid},
+		{name: "body appended", tamper: func(d *amqp.Delivery) { d.Body = append(d.Body, '!') }, wantErr: SignatureInvalid},
+		{name: "signed header value", tamper: func(d *amqp.Delivery) { d.Headers["tenant"] = "other" }, wantErr: SignatureInvalid},
+		{name: "signed header type", tamper: func(d *amqp.Delivery) { d.Headers["count"] = int64(3) }, wantErr: SignatureInvalid},
+		{name: "signed header removed", tamper: func(d *amqp.Delivery) { delete(d.Headers, "tenant") }, wantErr: SignatureInvalid},
+		{name: "header list shrunk", tamper: func(d *amqp.Delivery) { d.Headers[signatureHeadersHeader] = "count" }, wantErr: SignatureInvalid},
+		{
+			name: "header moved into body",
+			tamper: func(d *amqp.Delivery) {
+				d.Headers[signatureHeadersHeader] = "count"
+				d.Body = append([]byte("6:tenant"), d.Body...)
+			},
+			wantErr: SignatureInvalid,
+		},
+		{name: "required header not signed", required: []string{"user"}, wantErr: SignatureInvalid},
+		{name: "signature", tamper: func(d *amqp.Delivery) { d.Headers[signatureHeader].([]byte)[0] ^= 1 }, wantErr: SignatureInvalid},
+		{name: "signature missing", tamper: func(d *amqp.Delivery) { delete(d.Headers, signatureHeader) }, wantErr: SignatureMissing},
+		{name: "untrusted key", tamper: func(d *amqp.Delivery) { d.Headers[signatureKeyIdHeader] = "k2" }, wantErr: SignatureInvalid},
+		{name: "algorithm", tamper: func(d *amqp.Delivery) { d.Headers[signatureAlgHeader] = SignatureEd25519 }, wantErr: SignatureInvalid},
+		{name: "unsigned header", tamper: func(d *amqp.Delivery) { d.Headers["unsigned"] = "changed" }},
+	}
+	s := testSigners(t)[SignatureHMACSHA256]
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &signOptions{signer: s.signer, headers: []string{"count", "tenant"}}
+			d := signToDelivery(t, o, amqp.Publishing{
+				Headers: amqp.Table{"count": int32(3), "tenant": "acme", "unsigned": "u"},
+				Body:    []byte("hello"),
+			})
+			if tt.tamper != nil {
+				tt.tamper(d)
+			}
+			v := &verifyOptions{verifier: s.verifier, required: tt.required}
+			err := v.verifyDelivery(d)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("verifyDelivery = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("verifyDelivery = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSignUnsupportedHeaderType(t *testing.T) {
+	o := &signOptions{signer: NewHMACSigner("k1", []byte("key")), headers: []string{"h"}}
+	msg := amqp.Publishing{Headers: amqp.Table{"h": uint32(1)}}
+	if err := o.sign(&msg); err == nil {
+		t.Fatal("sign succeeded, want error for unsupported header type")
+	}
+}
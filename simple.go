@@ -17,8 +17,8 @@ import (
 */
 
 type SimplePublisher struct {
-	mqConn    *RMQConn // 连接
-	queueName string   // 生成的队列名称
+	*BasePublisher
+	queueName string // 生成的队列名称
 }
 
 // NewSimplePublisher 创建简单模式下的 publisher
@@ -26,7 +26,8 @@ type SimplePublisher struct {
 // queueName:不能为空
 // durable：持久化
 // autoDelete：自动删除
-func NewSimplePublisher(conn *RMQConn, queueName string, durable, autoDelete bool) (*SimplePublisher, error) {
+// opts：可选配置
+func NewSimplePublisher(conn *RMQConn, queueName string, durable, autoDelete bool, opts ...PublisherOption) (*SimplePublisher, error) {
 	if conn == nil {
 		return nil, ConnIsNil
 	}
@@ -34,8 +35,8 @@ func NewSimplePublisher(conn *RMQConn, queueName string, durable, autoDelete boo
 		return nil, QueueNameIsEmpty
 	}
 	r := &SimplePublisher{
-		mqConn:    conn,
-		queueName: queueName,
+		BasePublisher: NewBasePublisher(conn, opts...),
+		queueName:     queueName,
 	}
 	channel, err := r.mqConn.GetConn().Channel()
 	if err != nil {
@@ -43,6 +44,18 @@ func NewSimplePublisher(conn *RMQConn, queueName string, durable, autoDelete boo
 	}
 	defer channel.Close()
 
+	queueArgs, err := r.opts.queue.arguments(durable, autoDelete)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.opts.deadLetter {
+		queueArgs, err = deadLetterArguments(channel, queueName, queueArgs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// 1、申请队列,如果队列不存在则创建,存在则跳过
 	_, err = channel.QueueDeclare(
 		r.queueName,
@@ -50,7 +63,7 @@ func NewSimplePublisher(conn *RMQConn, queueName string, durable, autoDelete boo
 		autoDelete, // autoDelete
 		false,
 		false,
-		nil,
+		queueArgs,
 	)
 
 	if err != nil {
@@ -65,34 +78,33 @@ func NewSimplePublisher(conn *RMQConn, queueName string, durable, autoDelete boo
 // Publish
 // message：消息内容
 // expirationSecond：过期时间，秒；0 表示永不过期
-func (r *SimplePublisher) Publish(message []byte, expirationSecond uint64) (err error) {
-	channel, err := r.mqConn.GetConn().Channel()
-	if err != nil {
-		return err
-	}
-	defer channel.Close()
+// opts：单条消息的可选配置
+func (r *SimplePublisher) Publish(message []byte, expirationSecond uint64, opts ...PublishOption) (err error) {
 	expiration := ""
 	if expirationSecond > 0 {
 		expiration = fmt.Sprintf("%d", expirationSecond*1000)
 	}
-	err = channel.Publish(
+	err = r.publish(
 		"",          // exchange 交换机 simple 模式下默认为空，虽然为空，但其实也是在用的 rabbitmq 当中的 default 交换机运行
 		r.queueName, // routing key 在 simple 模式下，将路由 Key 设置为队列的名称
-		false,
-		false,
 		amqp.Publishing{
 			Expiration:   expiration,      // 过期毫秒数
 			DeliveryMode: amqp.Persistent, // 持久化
 			ContentType:  "text/plain",
 			Body:         message,
 			Timestamp:    time.Now(),
-		})
+		}, opts...)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// PublishMessage 发送自定义属性的消息，simple 模式下路由 key 固定为队列名，忽略 routingKey
+func (r *SimplePublisher) PublishMessage(routingKey string, msg amqp.Publishing) error {
+	return r.publish("", r.queueName, msg)
+}
+
 type SimpleConsumer struct {
 	*BaseConsumer
 }
@@ -102,7 +114,8 @@ type SimpleConsumer struct {
 // queueName：队列名，必填参数
 // durable：是否需要持久化
 // autoDelete：是否需要自动删除
-func NewSimpleConsumer(conn *RMQConn, queueName string, durable, autoDelete bool) (IConsumer, error) {
+// opts：可选配置
+func NewSimpleConsumer(conn *RMQConn, queueName string, durable, autoDelete bool, opts ...ConsumerOption) (IConsumer, error) {
 	if conn == nil {
 		return nil, ConnIsNil
 	}
@@ -115,6 +128,19 @@ func NewSimpleConsumer(conn *RMQConn, queueName string, durable, autoDelete bool
 	}
 	defer channel.Close()
 
+	o := newConsumerOptions(opts)
+	queueArgs, err := o.queue.arguments(durable, autoDelete)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.deadLetter {
+		queueArgs, err = deadLetterArguments(channel, queueName, queueArgs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// 1、申请队列,如果队列不存在则创建,存在则跳过
 	q, err := channel.QueueDeclare(
 		queueName,  // 队列名
@@ -122,12 +148,12 @@ func NewSimpleConsumer(conn *RMQConn, queueName string, durable, autoDelete bool
 		autoDelete, // autoDelete
 		false,
 		false,
-		nil,
+		queueArgs,
 	)
 	if err != nil {
 		return nil, err
 	}
 	c := &SimpleConsumer{}
-	c.BaseConsumer = NewBaseConsumer(conn, DefaultPrefetchCount, q.Name, c)
+	c.BaseConsumer = NewBaseConsumer(conn, DefaultPrefetchCount, q.Name, c, opts...)
 	return c, nil
 }
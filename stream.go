@@ -0,0 +1,233 @@
+package rbmq
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/streadway/amqp"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+8 Stream 流模式，消息追加写入 stream 队列（x-queue-type=stream），消费后不会被删除，可以从任意位置重复读取
+
+	应用场景: 事件回放，审计日志，新服务从历史消息开始构建数据
+
+StreamConsumer 通过 x-stream-offset 指定开始读取的位置，每条消息处理成功并 ack 后把它的 offset 保存到 OffsetStore，
+断网重连或者进程重启后从保存的 offset 的下一条继续读取，没有保存过 offset 时从创建时指定的位置开始。
+注意 stream 队列不会重新投递处理失败的消息，失败的消息不会保存 offset，但后面的消息处理成功后 offset 会越过它。
+stream 队列不支持重试策略和死信队列，指定 WithRetryPolicy 或 WithDeadLetterQueue 时创建返回 QueueOptionsInvalid。
+*/
+
+const streamOffsetHeader = "x-stream-offset"
+
+// StreamOffset stream 开始读取的位置
+type StreamOffset struct {
+	value interface{}
+}
+
+var (
+	StreamOffsetFirst = StreamOffset{value: "first"} // 从第一条消息开始
+	StreamOffsetLast  = StreamOffset{value: "last"}  // 从最后一个 chunk 开始
+	StreamOffsetNext  = StreamOffset{value: "next"}  // 只读取新消息
+)
+
+// StreamOffsetAt 从指定的 offset 开始
+func StreamOffsetAt(offset int64) StreamOffset {
+	return StreamOffset{value: offset}
+}
+
+// StreamOffsetTimestamp 从指定时间之后的消息开始，精度为秒
+func StreamOffsetTimestamp(t time.Time) StreamOffset {
+	return StreamOffset{value: t}
+}
+
+// OffsetStore 保存 stream consumer 已处理的 offset
+type OffsetStore interface {
+	Load(name string) (offset int64, ok bool, err error) // 没有保存过时 ok 为 false
+	Save(name string, offset int64) error
+}
+
+// FileOffsetStore 基于本地文件的 OffsetStore，每个 consumer 一个文件
+type FileOffsetStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileOffsetStore 创建 FileOffsetStore，目录不存在时自动创建
+func NewFileOffsetStore(dir string) (*FileOffsetStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileOffsetStore{dir: dir}, nil
+}
+
+func (s *FileOffsetStore) Load(name string) (int64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := os.ReadFile(s.path(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	offset, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return offset, true, nil
+}
+
+func (s *FileOffsetStore) Save(name string, offset int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// 先写临时文件再重命名，进程崩溃时不会留下写了一半的文件
+	path := s.path(name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatInt(offset, 10)), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// path consumer 名可能包含任意字符，编码后作为文件名
+func (s *FileOffsetStore) path(name string) string {
+	return filepath.Join(s.dir, hex.EncodeToString([]byte(name))+".offset")
+}
+
+type StreamConsumer struct {
+	*BaseConsumer
+	name  string // consumer 名，用于保存 offset，同一个 stream 的不同 consumer 需要不同的名字
+	start StreamOffset
+	store OffsetStore
+
+	mu         sync.Mutex
+	lastOffset int64 // 本进程内最后处理成功的 offset，-1 表示还没有
+}
+
+// NewStreamConsumer 创建 stream consumer
+// conn：rabbit mq 连接
+// queueName：stream 队列名，不能为空，不存在时自动创建，stream 队列必须持久化
+// name：consumer 名，不能为空，用于保存和恢复 offset
+// start：没有保存过 offset 时开始读取的位置
+// store：保存 offset，为空时不保存，每次都从 start 开始
+// opts：可选配置，可以通过 WithQueueOptions 的 Args 指定 x-max-age 等 stream 参数，队列类型固定为 stream，
+// 不支持 WithRetryPolicy 和 WithDeadLetterQueue
+func NewStreamConsumer(conn *RMQConn, queueName, name string, start StreamOffset, store OffsetStore, opts ...ConsumerOption) (*StreamConsumer, error) {
+	if conn == nil {
+		return nil, ConnIsNil
+	}
+	if queueName == "" {
+		return nil, QueueNameIsEmpty
+	}
+	if name == "" {
+		return nil, StreamConsumerNameIsEmpty
+	}
+
+	o := newConsumerOptions(opts)
+	// 重试队列会把消息死信回 stream，作为新消息追加，所有读取该 stream 的 consumer 都会再读到一次
+	if o.retry != nil {
+		return nil, fmt.Errorf("%w: stream queue does not support retry policy", QueueOptionsInvalid)
+	}
+	if o.deadLetter {
+		return nil, fmt.Errorf("%w: stream queue does not support dead lettering", QueueOptionsInvalid)
+	}
+	var queueOpts QueueOptions
+	if o.queue != nil {
+		queueOpts = *o.queue
+	}
+	if queueOpts.Type != "" && queueOpts.Type != QueueTypeStream {
+		return nil, fmt.Errorf("%w: stream consumer requires stream queue, got %s", QueueOptionsInvalid, queueOpts.Type)
+	}
+	queueOpts.Type = QueueTypeStream
+	queueArgs, err := queueOpts.arguments(true, false)
+	if err != nil {
+		return nil, err
+	}
+
+	channel, err := conn.GetConn().Channel()
+	if err != nil {
+		return nil, err
+	}
+	defer channel.Close()
+
+	// 申请 stream 队列，stream 队列必须持久化、不能自动删除
+	q, err := channel.QueueDeclare(
+		queueName,
+		true,
+		false,
+		false,
+		false,
+		queueArgs,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &StreamConsumer{
+		name:       name,
+		start:      start,
+		store:      store,
+		lastOffset: -1,
+	}
+	c.BaseConsumer = NewBaseConsumer(conn, DefaultPrefetchCount, q.Name, c, opts...)
+	c.consumeArgs = c.offsetArgs
+	c.onAck = c.saveOffset
+	return c, nil
+}
+
+// offsetArgs 每次（重新）监听时计算开始读取的位置：本进程内处理过的 > OffsetStore 中保存的 > 创建时指定的
+func (c *StreamConsumer) offsetArgs() amqp.Table {
+	c.mu.Lock()
+	lastOffset := c.lastOffset
+	c.mu.Unlock()
+	if lastOffset >= 0 {
+		return amqp.Table{streamOffsetHeader: lastOffset + 1}
+	}
+	if c.store != nil {
+		offset, ok, err := c.store.Load(c.name)
+		if err != nil {
+			// 读取失败时从指定位置开始，宁可重复也不丢失
+			log.Printf("StreamConsumer: load offset of %s: %s\n", c.name, err)
+		} else if ok {
+			return amqp.Table{streamOffsetHeader: offset + 1}
+		}
+	}
+	return amqp.Table{streamOffsetHeader: c.start.value}
+}
+
+// saveOffset 消息处理成功后保存它的 offset
+func (c *StreamConsumer) saveOffset(d amqp.Delivery) {
+	offset, ok := d.Headers[streamOffsetHeader].(int64)
+	if !ok {
+		return
+	}
+	// 持有锁保存，保证保存的 offset 只增不减
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if offset <= c.lastOffset {
+		return
+	}
+	c.lastOffset = offset
+	if c.store == nil {
+		return
+	}
+	if err := c.store.Save(c.name, offset); err != nil {
+		log.Printf("StreamConsumer: save offset of %s: %s\n", c.name, err)
+	}
+}
+
+// Offset 返回本进程内最后处理成功的 offset，还没有处理过消息时 ok 为 false
+func (c *StreamConsumer) Offset() (offset int64, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastOffset, c.lastOffset >= 0
+}
@@ -0,0 +1,26 @@
+package rbmq
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewStreamConsumerRejectsOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []ConsumerOption
+	}{
+		{name: "retry policy", opts: []ConsumerOption{WithRetryPolicy(RetryPolicy{MaxAttempts: 3})}},
+		{name: "dead letter queue", opts: []ConsumerOption{WithDeadLetterQueue()}},
+		{name: "classic queue", opts: []ConsumerOption{WithQueueOptions(QueueOptions{Type: QueueTypeClassic})}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 参数校验在连接 broker 之前完成
+			_, err := NewStreamConsumer(&RMQConn{}, "events", "reader", StreamOffsetFirst, nil, tt.opts...)
+			if !errors.Is(err, QueueOptionsInvalid) {
+				t.Errorf("NewStreamConsumer = %v, want QueueOptionsInvalid", err)
+			}
+		})
+	}
+}
@@ -13,7 +13,7 @@ import (
 */
 
 type SubscriptionPublisher struct {
-	mqConn       *RMQConn // 连接
+	*BasePublisher
 	exchangeName string
 }
 
@@ -22,7 +22,8 @@ type SubscriptionPublisher struct {
 // exchangeName：不能为空
 // durable：持久化
 // autoDelete：自动删除
-func NewSubscriptionPublisher(conn *RMQConn, exchangeName string, durable, autoDelete bool) (*SubscriptionPublisher, error) {
+// opts：可选配置
+func NewSubscriptionPublisher(conn *RMQConn, exchangeName string, durable, autoDelete bool, opts ...PublisherOption) (*SubscriptionPublisher, error) {
 	if conn == nil {
 		return nil, ConnIsNil
 	}
@@ -31,8 +32,8 @@ func NewSubscriptionPublisher(conn *RMQConn, exchangeName string, durable, autoD
 	}
 	//创建 rabbitmq 实例
 	r := &SubscriptionPublisher{
-		mqConn:       conn,
-		exchangeName: exchangeName,
+		BasePublisher: NewBasePublisher(conn, opts...),
+		exchangeName:  exchangeName,
 	}
 	channel, err := r.mqConn.GetConn().Channel()
 	if err != nil {
@@ -40,15 +41,17 @@ func NewSubscriptionPublisher(conn *RMQConn, exchangeName string, durable, autoD
 	}
 	defer channel.Close()
 
+	kind, internal, exchangeArgs := r.opts.exchange.declareArgs("fanout")
+
 	// 申请交换机,如果交换机不存在则创建,存在则跳过
 	err = channel.ExchangeDeclare(
 		r.exchangeName,
-		"fanout",   // 交换机类型，fanout 发布订阅模式
+		kind,       // 交换机类型，fanout 发布订阅模式
 		durable,    // 是否持久化
 		autoDelete, // 自动删除
+		internal,
 		false,
-		false,
-		nil,
+		exchangeArgs,
 	)
 	if err != nil {
 		return nil, err
@@ -59,35 +62,34 @@ func NewSubscriptionPublisher(conn *RMQConn, exchangeName string, durable, autoD
 // Publish
 // message：消息内容
 // expirationSecond：过期时间，秒；0 表示永不过期
-func (r *SubscriptionPublisher) Publish(message []byte, expirationSecond uint64) (err error) {
-	channel, err := r.mqConn.GetConn().Channel()
-	if err != nil {
-		return err
-	}
-	defer channel.Close()
+// opts：单条消息的可选配置
+func (r *SubscriptionPublisher) Publish(message []byte, expirationSecond uint64, opts ...PublishOption) (err error) {
 	expiration := ""
 	if expirationSecond > 0 {
 		expiration = fmt.Sprintf("%d", expirationSecond*1000)
 	}
 	// 发送消息
-	err = channel.Publish(
+	err = r.publish(
 		r.exchangeName,
 		"", // key 路由参数，fanout 类型交换机，自动忽略路由参数，填了也没用。
-		false,
-		false,
 		amqp.Publishing{
 			Expiration:   expiration,      // 过期毫秒数
 			DeliveryMode: amqp.Persistent, // 持久化
 			ContentType:  "text/plain",
 			Body:         message,
 			Timestamp:    time.Now(),
-		})
+		}, opts...)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// PublishMessage 发送自定义属性的消息，fanout 类型交换机忽略 routingKey
+func (r *SubscriptionPublisher) PublishMessage(routingKey string, msg amqp.Publishing) error {
+	return r.publish(r.exchangeName, "", msg)
+}
+
 type SubscriptionConsumer struct {
 	*BaseConsumer
 }
@@ -98,7 +100,8 @@ type SubscriptionConsumer struct {
 // queueName：为空时，自动生成，为空时，队列强制为非持久化和自动删除
 // durable：是否需要持久化
 // autoDelete：是否需要自动删除
-func NewSubscriptionConsumer(conn *RMQConn, exchangeName, queueName string, durable, autoDelete bool) (IConsumer, error) {
+// opts：可选配置
+func NewSubscriptionConsumer(conn *RMQConn, exchangeName, queueName string, durable, autoDelete bool, opts ...ConsumerOption) (IConsumer, error) {
 	if conn == nil {
 		return nil, ConnIsNil
 	}
@@ -112,15 +115,18 @@ func NewSubscriptionConsumer(conn *RMQConn, exchangeName, queueName string, dura
 	}
 	defer channel.Close()
 
+	o := newConsumerOptions(opts)
+	kind, internal, exchangeArgs := o.exchange.declareArgs("fanout")
+
 	// 1、申请交换机,如果交换机不存在则创建,存在则跳过
 	err = channel.ExchangeDeclare(
 		exchangeName,
-		"fanout",   // 交换机类型，fanout 发布订阅模式
+		kind,       // 交换机类型，fanout 发布订阅模式
 		durable,    // 是否持久化
 		autoDelete, // 自动删除
+		internal,
 		false,
-		false,
-		nil,
+		exchangeArgs,
 	)
 	if err != nil {
 		return nil, err
@@ -132,6 +138,22 @@ func NewSubscriptionConsumer(conn *RMQConn, exchangeName, queueName string, dura
 		autoDelete = true
 	}
 
+	queueArgs, err := o.queue.arguments(durable, autoDelete)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = o.checkRetry(queueName); err != nil {
+		return nil, err
+	}
+
+	if o.deadLetter {
+		queueArgs, err = deadLetterArguments(channel, queueName, queueArgs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	//2、申请队列,如果队列不存在则创建,存在则跳过
 	q, err := channel.QueueDeclare(
 		queueName,  // 队列名字，不填则随机生成一个
@@ -139,7 +161,7 @@ func NewSubscriptionConsumer(conn *RMQConn, exchangeName, queueName string, dura
 		autoDelete, // 自动删除
 		false,      // exclusive
 		false,      // no-wait
-		nil,        // arguments
+		queueArgs,  // arguments
 	)
 	if err != nil {
 		return nil, err
@@ -157,6 +179,6 @@ func NewSubscriptionConsumer(conn *RMQConn, exchangeName, queueName string, dura
 		return nil, err
 	}
 	c := &SubscriptionConsumer{}
-	c.BaseConsumer = NewBaseConsumer(conn, DefaultPrefetchCount, q.Name, c)
+	c.BaseConsumer = NewBaseConsumer(conn, DefaultPrefetchCount, q.Name, c, opts...)
 	return c, nil
 }
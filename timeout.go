@@ -0,0 +1,87 @@
+package rbmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+/*
+关于处理超时
+处理函数一直不返回时消息一直不会 ack，超过 broker 的 consumer_timeout（默认 30 分钟）后 broker 会关闭整个通道。
+通过 WithHandlerTimeout 指定处理超时，超时后取消处理函数的 ctx，不再等待处理函数返回，立即按 TimeoutOutcome 处理该消息：
+
+(1) TimeoutRequeue：nack 并重新入队
+(2) TimeoutReject：nack 不重新入队，配置了死信的队列会转入死信
+(3) TimeoutRetry：按 WithRetryPolicy 指定的重试策略重试，没有重试策略时与 TimeoutRequeue 相同
+
+超时后处理函数仍然在后台运行，返回值被忽略，处理函数需要关注 ctx 及时退出，否则串行处理时后面的消息会与它同时运行。
+超时只对逐条处理生效，批量消费不受影响。
+*/
+
+type TimeoutOutcome int
+
+const (
+	TimeoutRequeue TimeoutOutcome = iota // nack 并重新入队
+	TimeoutReject                        // nack 不重新入队
+	TimeoutRetry                         // 按重试策略重试
+)
+
+// WithHandlerTimeout 指定处理函数的超时
+// d：超时时间，小于等于 0 时不超时
+// outcome：超时后消息的处理方式
+func WithHandlerTimeout(d time.Duration, outcome TimeoutOutcome) ConsumerOption {
+	return consumerOptionFunc(func(o *consumerOptions) {
+		o.handlerTimeout = d
+		o.timeoutOutcome = outcome
+	})
+}
+
+// call 调用处理函数，配置了超时时超时后取消处理函数的 ctx，并且不再等待处理函数返回，返回 HandlerTimeout
+func (c *BaseConsumer) call(ctx context.Context, msg *Message, handler MessageHandler) error {
+	d := c.opts.handlerTimeout
+	if d <= 0 {
+		return c.invoke(func() error { return handler(ctx, msg) })
+	}
+	hctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- c.invoke(func() error { return handler(hctx, msg) })
+	}()
+	// 使用单独的定时器，Stop 超时取消 ctx 时仍然等待处理函数返回
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case err := <-result:
+		// 关注 ctx 的处理函数在超时的同时返回错误，可能先于定时器被选中，同样按超时处理
+		if err != nil && errors.Is(hctx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+			return fmt.Errorf("%w after %s: %v", HandlerTimeout, d, err)
+		}
+		return err
+	case <-timer.C:
+		return fmt.Errorf("%w after %s", HandlerTimeout, d)
+	}
+}
+
+// timedOut 按 TimeoutOutcome 处理超时的消息
+func (c *BaseConsumer) timedOut(f *inflight, err error) {
+	switch c.opts.timeoutOutcome {
+	case TimeoutRetry:
+		if c.opts.retry != nil {
+			c.retry(f.raw, err)
+			return
+		}
+	case TimeoutReject:
+		if err = f.d.Nack(false, false); err != nil {
+			log.Printf("deliver.Nack: %s\n", err)
+		}
+		return
+	}
+	if err = f.d.Nack(false, true); err != nil {
+		log.Printf("deliver.Nack: %s\n", err)
+	}
+}
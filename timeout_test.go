@@ -0,0 +1,85 @@
+package rbmq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCallTimeout(t *testing.T) {
+	failed := errors.New("failed")
+	tests := []struct {
+		name        string
+		handler     MessageHandler
+		stop        bool // 处理过程中取消外层 ctx，模拟 Stop 超时
+		wantErr     error
+		wantTimeout bool
+	}{
+		{
+			name:    "returns in time",
+			handler: func(ctx context.Context, msg *Message) error { return nil },
+		},
+		{
+			name:    "fails in time",
+			handler: func(ctx context.Context, msg *Message) error { return failed },
+			wantErr: failed,
+		},
+		{
+			name: "honours ctx",
+			handler: func(ctx context.Context, msg *Message) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			wantTimeout: true,
+		},
+		{
+			name: "ignores ctx",
+			handler: func(ctx context.Context, msg *Message) error {
+				time.Sleep(20 * time.Millisecond)
+				return failed
+			},
+			wantTimeout: true,
+		},
+		{
+			name: "stopped",
+			handler: func(ctx context.Context, msg *Message) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			stop:    true,
+			wantErr: context.Canceled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &BaseConsumer{opts: consumerOptions{handlerTimeout: 5 * time.Millisecond}}
+			if tt.stop {
+				c.opts.handlerTimeout = time.Minute
+			}
+			// 处理函数返回与定时器触发同时发生时结果不确定，多次调用覆盖两种顺序
+			for i := 0; i < 50; i++ {
+				ctx, cancel := context.WithCancel(context.Background())
+				if tt.stop {
+					time.AfterFunc(time.Millisecond, cancel)
+				}
+				err := c.call(ctx, &Message{}, tt.handler)
+				cancel()
+				switch {
+				case tt.wantTimeout:
+					if !errors.Is(err, HandlerTimeout) {
+						t.Fatalf("call = %v, want HandlerTimeout", err)
+					}
+				case tt.wantErr != nil:
+					if !errors.Is(err, tt.wantErr) || errors.Is(err, HandlerTimeout) {
+						t.Fatalf("call = %v, want %v", err, tt.wantErr)
+					}
+				default:
+					if err != nil {
+						t.Fatalf("call = %v, want nil", err)
+					}
+				}
+			}
+		})
+	}
+}
@@ -13,7 +13,7 @@ import (
 */
 
 type TopicPublisher struct {
-	mqConn       *RMQConn // 连接
+	*BasePublisher
 	exchangeName string
 }
 
@@ -22,7 +22,8 @@ type TopicPublisher struct {
 // exchangeName：不能为空
 // durable：持久化
 // autoDelete：自动删除
-func NewTopicPublisher(conn *RMQConn, exchangeName string, durable, autoDelete bool) (*TopicPublisher, error) {
+// opts：可选配置
+func NewTopicPublisher(conn *RMQConn, exchangeName string, durable, autoDelete bool, opts ...PublisherOption) (*TopicPublisher, error) {
 	if conn == nil {
 		return nil, ConnIsNil
 	}
@@ -30,8 +31,8 @@ func NewTopicPublisher(conn *RMQConn, exchangeName string, durable, autoDelete b
 		return nil, ExchangeNameIsEmpty
 	}
 	r := &TopicPublisher{
-		mqConn:       conn,
-		exchangeName: exchangeName,
+		BasePublisher: NewBasePublisher(conn, opts...),
+		exchangeName:  exchangeName,
 	}
 
 	channel, err := r.mqConn.GetConn().Channel()
@@ -40,15 +41,17 @@ func NewTopicPublisher(conn *RMQConn, exchangeName string, durable, autoDelete b
 	}
 	defer channel.Close()
 
+	kind, internal, exchangeArgs := r.opts.exchange.declareArgs("topic")
+
 	// 尝试创建交换机,这里的 kind 的类型要改为 topic
 	err = channel.ExchangeDeclare(
 		exchangeName,
-		"topic",
+		kind,
 		durable,
 		autoDelete,
+		internal,
 		false,
-		false,
-		nil,
+		exchangeArgs,
 	)
 	if err != nil {
 		return nil, err
@@ -60,38 +63,40 @@ func NewTopicPublisher(conn *RMQConn, exchangeName string, durable, autoDelete b
 // Publish
 // message：消息内容
 // expirationSecond：过期时间，秒；0 表示永不过期
-func (r *TopicPublisher) Publish(message []byte, routingKey string, expirationSecond uint64) (err error) {
+// opts：单条消息的可选配置
+func (r *TopicPublisher) Publish(message []byte, routingKey string, expirationSecond uint64, opts ...PublishOption) (err error) {
 	if len(routingKey) == 0 {
 		return RoutingKeyIsRequired
 	}
-	channel, err := r.mqConn.GetConn().Channel()
-	if err != nil {
-		return err
-	}
-	defer channel.Close()
 	expiration := ""
 	if expirationSecond > 0 {
 		expiration = fmt.Sprintf("%d", expirationSecond*1000)
 	}
 	// 发送消息。
-	err = channel.Publish(
+	err = r.publish(
 		r.exchangeName,
 		routingKey,
-		false,
-		false,
 		amqp.Publishing{
 			Expiration:   expiration,      // 过期毫秒数
 			DeliveryMode: amqp.Persistent, // 持久化
 			ContentType:  "text/plain",
 			Body:         message,
 			Timestamp:    time.Now(),
-		})
+		}, opts...)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// PublishMessage 发送自定义属性的消息
+func (r *TopicPublisher) PublishMessage(routingKey string, msg amqp.Publishing) error {
+	if len(routingKey) == 0 {
+		return RoutingKeyIsRequired
+	}
+	return r.publish(r.exchangeName, routingKey, msg)
+}
+
 type TopicConsumer struct {
 	*BaseConsumer
 }
@@ -103,7 +108,8 @@ type TopicConsumer struct {
 // routingKey:不能为空
 // durable：持久化
 // autoDelete：自动删除
-func NewTopicConsumer(conn *RMQConn, exchangeName, queueName, routingKey string, durable, autoDelete bool) (IConsumer, error) {
+// opts：可选配置
+func NewTopicConsumer(conn *RMQConn, exchangeName, queueName, routingKey string, durable, autoDelete bool, opts ...ConsumerOption) (IConsumer, error) {
 	if conn == nil {
 		return nil, ConnIsNil
 	}
@@ -121,15 +127,18 @@ func NewTopicConsumer(conn *RMQConn, exchangeName, queueName, routingKey string,
 	}
 	defer channel.Close()
 
+	o := newConsumerOptions(opts)
+	kind, internal, exchangeArgs := o.exchange.declareArgs("topic")
+
 	// 尝试创建交换机,这里的 kind 的类型要改为 topic
 	err = channel.ExchangeDeclare(
 		exchangeName,
-		"topic",
+		kind,
 		durable,
 		autoDelete,
+		internal,
 		false,
-		false,
-		nil,
+		exchangeArgs,
 	)
 	if err != nil {
 		return nil, err
@@ -141,6 +150,22 @@ func NewTopicConsumer(conn *RMQConn, exchangeName, queueName, routingKey string,
 		autoDelete = true
 	}
 
+	queueArgs, err := o.queue.arguments(durable, autoDelete)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = o.checkRetry(queueName); err != nil {
+		return nil, err
+	}
+
+	if o.deadLetter {
+		queueArgs, err = deadLetterArguments(channel, queueName, queueArgs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	//2 尝试创建队列，存在自动跳过
 	q, err := channel.QueueDeclare(
 		queueName,
@@ -148,7 +173,7 @@ func NewTopicConsumer(conn *RMQConn, exchangeName, queueName, routingKey string,
 		autoDelete,
 		false,
 		false,
-		nil,
+		queueArgs,
 	)
 	if err != nil {
 		return nil, err
@@ -167,7 +192,7 @@ func NewTopicConsumer(conn *RMQConn, exchangeName, queueName, routingKey string,
 	}
 
 	c := &TopicConsumer{}
-	c.BaseConsumer = NewBaseConsumer(conn, DefaultPrefetchCount, q.Name, c)
+	c.BaseConsumer = NewBaseConsumer(conn, DefaultPrefetchCount, q.Name, c, opts...)
 
 	return c, nil
 }
@@ -0,0 +1,302 @@
+package rbmq
+
+import (
+	"github.com/streadway/amqp"
+	"log"
+	"sync"
+)
+
+/*
+关于多级路由
+交换机之间可以像队列一样绑定，消息先路由到源交换机，再按绑定关系转发到目标交换机，目标交换机再路由到队列，例如：
+
+	topic 交换机 events ──(team.a.#)──> fanout 交换机 team.a ──> 队列 team.a.audit、team.a.worker
+	                     └─(team.b.#)──> fanout 交换机 team.b ──> 队列 team.b.worker
+
+只用于转发的中间交换机可以通过 ExchangeOptions.Internal 设置为内部交换机，禁止 client 直接推送消息。
+
+通过 RMQConn.DeclareExchange 和 RMQConn.BindExchange 申请的交换机和绑定关系会被记录下来，
+断网重连后在新连接可用之前重新申请，broker 重启后非持久化的交换机和绑定关系也能恢复。
+*/
+
+type exchangeDeclaration struct {
+	name       string
+	kind       string
+	durable    bool
+	autoDelete bool
+	opts       *ExchangeOptions
+}
+
+type exchangeBinding struct {
+	destination string
+	key         string
+	source      string
+	args        amqp.Table
+}
+
+// topology 记录通过 RMQConn 申请的交换机和交换机之间的绑定，用于重连后恢复
+type topology struct {
+	mu        sync.Mutex
+	exchanges []exchangeDeclaration
+	bindings  []exchangeBinding
+}
+
+func (t *topology) addExchange(e exchangeDeclaration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.exchanges {
+		if t.exchanges[i].name == e.name {
+			t.exchanges[i] = e
+			return
+		}
+	}
+	t.exchanges = append(t.exchanges, e)
+}
+
+func (t *topology) removeExchange(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.exchanges {
+		if t.exchanges[i].name == name {
+			t.exchanges = append(t.exchanges[:i], t.exchanges[i+1:]...)
+			break
+		}
+	}
+	// 交换机删除后 broker 会同时删除它作为源或目标的绑定
+	bindings := t.bindings[:0]
+	for _, b := range t.bindings {
+		if b.source != name && b.destination != name {
+			bindings = append(bindings, b)
+		}
+	}
+	t.bindings = bindings
+}
+
+func (t *topology) addBinding(b exchangeBinding) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.indexBinding(b) < 0 {
+		t.bindings = append(t.bindings, b)
+	}
+}
+
+func (t *topology) removeBinding(b exchangeBinding) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if i := t.indexBinding(b); i >= 0 {
+		t.bindings = append(t.bindings[:i], t.bindings[i+1:]...)
+	}
+}
+
+// indexBinding 与 broker 一致，以源、目标、路由 key 和参数区分绑定关系
+func (t *topology) indexBinding(b exchangeBinding) int {
+	for i, e := range t.bindings {
+		if e.destination == b.destination && e.key == b.key && e.source == b.source && tableEqual(e.args, b.args) {
+			return i
+		}
+	}
+	return -1
+}
+
+// recover 在新连接上重新申请记录的交换机和绑定关系，单个失败只记录日志，不影响其他的恢复
+// 恢复过程中的任何错误（包括 panic）都不会传给调用方，保证重连后新连接一定会被使用
+func (t *topology) recover(conn *amqp.Connection) {
+	defer func() {
+		if pErr := recover(); pErr != nil {
+			log.Printf("topology: recover: %v \n", pErr)
+		}
+	}()
+
+	t.mu.Lock()
+	exchanges := append([]exchangeDeclaration(nil), t.exchanges...)
+	bindings := append([]exchangeBinding(nil), t.bindings...)
+	t.mu.Unlock()
+	if len(exchanges) == 0 && len(bindings) == 0 {
+		return
+	}
+
+	var channel *amqp.Channel
+	defer func() {
+		if channel != nil {
+			channel.Close()
+		}
+	}()
+	// 通道出错后会被 broker 关闭，关闭旧通道并重新打开后继续恢复剩下的
+	reopen := func() bool {
+		if channel != nil {
+			channel.Close()
+			channel = nil
+		}
+		ch, err := conn.Channel()
+		if err != nil {
+			log.Printf("topology: recover: %v \n", err)
+			return false
+		}
+		channel = ch
+		return true
+	}
+	if !reopen() {
+		return
+	}
+	for _, e := range exchanges {
+		kind, internal, args := e.opts.declareArgs(e.kind)
+		if err := channel.ExchangeDeclare(e.name, kind, e.durable, e.autoDelete, internal, false, args); err != nil {
+			log.Printf("topology: recover exchange %s: %v \n", e.name, err)
+			if !reopen() {
+				return
+			}
+		}
+	}
+	for _, b := range bindings {
+		if err := channel.ExchangeBind(b.destination, b.key, b.source, false, b.args); err != nil {
+			log.Printf("topology: recover binding %s -> %s (%s): %v \n", b.source, b.destination, b.key, err)
+			if !reopen() {
+				return
+			}
+		}
+	}
+}
+
+// tableEqual 比较绑定参数，参数值只支持 amqp.Table 允许的可比较类型
+func tableEqual(a, b amqp.Table) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		w, ok := b[k]
+		if !ok || !valueEqual(v, w) {
+			return false
+		}
+	}
+	return true
+}
+
+func valueEqual(a, b interface{}) bool {
+	switch av := a.(type) {
+	case amqp.Table:
+		bv, ok := b.(amqp.Table)
+		return ok && tableEqual(av, bv)
+	case []byte:
+		bv, ok := b.([]byte)
+		return ok && string(av) == string(bv)
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !valueEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	case amqp.Decimal:
+		bv, ok := b.(amqp.Decimal)
+		return ok && av == bv
+	default:
+		return a == b
+	}
+}
+
+// DeclareExchange 申请交换机，重连后会自动重新申请
+// name：交换机名，不能为空
+// kind：交换机类型，例如 direct、topic、fanout、headers
+// durable：持久化
+// autoDelete：自动删除
+// opts：交换机参数，可为空
+func (r *RMQConn) DeclareExchange(name, kind string, durable, autoDelete bool, opts *ExchangeOptions) error {
+	if name == "" {
+		return ExchangeNameIsEmpty
+	}
+	channel, err := r.GetConn().Channel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+
+	kind, internal, args := opts.declareArgs(kind)
+	err = channel.ExchangeDeclare(name, kind, durable, autoDelete, internal, false, args)
+	if err != nil {
+		return err
+	}
+	r.topology.addExchange(exchangeDeclaration{
+		name:       name,
+		kind:       kind,
+		durable:    durable,
+		autoDelete: autoDelete,
+		opts:       opts,
+	})
+	return nil
+}
+
+// DeleteExchange 删除交换机，同时删除记录的交换机和相关的绑定关系
+func (r *RMQConn) DeleteExchange(name string) error {
+	channel, err := r.GetConn().Channel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+
+	err = channel.ExchangeDelete(
+		name,
+		false, // ifUnused
+		false, // noWait
+	)
+	if err != nil {
+		return err
+	}
+	r.topology.removeExchange(name)
+	return nil
+}
+
+// BindExchange 把目标交换机绑定到源交换机，源交换机路由到该绑定的消息会转发到目标交换机，重连后会自动重新绑定
+// destination：目标交换机，不能为空
+// routingKey：绑定关系中的 key，含义与源交换机的类型有关
+// source：源交换机，不能为空
+// args：绑定参数，例如 headers 交换机的匹配条件，可为空
+func (r *RMQConn) BindExchange(destination, routingKey, source string, args amqp.Table) error {
+	if destination == "" || source == "" {
+		return ExchangeNameIsEmpty
+	}
+	channel, err := r.GetConn().Channel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+
+	err = channel.ExchangeBind(destination, routingKey, source, false, args)
+	if err != nil {
+		return err
+	}
+	r.topology.addBinding(exchangeBinding{
+		destination: destination,
+		key:         routingKey,
+		source:      source,
+		args:        args,
+	})
+	return nil
+}
+
+// UnbindExchange 解除 BindExchange 建立的绑定关系，参数需要与绑定时一致
+func (r *RMQConn) UnbindExchange(destination, routingKey, source string, args amqp.Table) error {
+	if destination == "" || source == "" {
+		return ExchangeNameIsEmpty
+	}
+	channel, err := r.GetConn().Channel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+
+	err = channel.ExchangeUnbind(destination, routingKey, source, false, args)
+	if err != nil {
+		return err
+	}
+	r.topology.removeBinding(exchangeBinding{
+		destination: destination,
+		key:         routingKey,
+		source:      source,
+		args:        args,
+	})
+	return nil
+}
@@ -0,0 +1,91 @@
+package rbmq
+
+import (
+	"context"
+	"fmt"
+	"github.com/streadway/amqp"
+	"reflect"
+	"time"
+)
+
+/*
+关于类型化的收发
+TypedPublisher[T] 包装任意 publisher，发送前用 Codec 把 T 编码为消息体，并把 Codec 的 ContentType 写入消息。
+ConsumeTyped[T] 在任意 consumer 上监听，根据消息的 ContentType 选择已注册的 Codec 解码为 T，
+ContentType 没有注册时（例如旧的 text/plain 消息）使用传入的默认 Codec。
+解码失败的消息重试也无法处理，直接拒绝，不会重新入队。
+*/
+
+type TypedPublisher[T any] struct {
+	publisher IPublisher
+	codec     Codec
+}
+
+// NewTypedPublisher 创建类型化的 publisher
+// p：任意模式的 publisher
+// codec：编码方式
+func NewTypedPublisher[T any](p IPublisher, codec Codec) *TypedPublisher[T] {
+	return &TypedPublisher[T]{
+		publisher: p,
+		codec:     codec,
+	}
+}
+
+// Publish
+// v：消息内容
+// routingKey：路由 key，含义与对应模式 publisher 的 Publish 一致
+// expirationSecond：过期时间，秒；0 表示永不过期
+// opts：单条消息的可选配置
+func (t *TypedPublisher[T]) Publish(v T, routingKey string, expirationSecond uint64, opts ...PublishOption) error {
+	body, err := t.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	expiration := ""
+	if expirationSecond > 0 {
+		expiration = fmt.Sprintf("%d", expirationSecond*1000)
+	}
+	msg := amqp.Publishing{
+		Expiration:   expiration,      // 过期毫秒数
+		DeliveryMode: amqp.Persistent, // 持久化
+		ContentType:  t.codec.ContentType(),
+		Body:         body,
+		Timestamp:    time.Now(),
+	}
+	for _, opt := range opts {
+		opt(&msg)
+	}
+	return t.publisher.PublishMessage(routingKey, msg)
+}
+
+// TypedHandler 类型化的处理函数
+type TypedHandler[T any] func(ctx context.Context, v T) error
+
+// ConsumeTyped 在 consumer 上监听并把消息解码为 T 后调用 handler，该方法会阻塞调用，建议开启一个单独的 goroutine 调用
+// c：任意模式的 consumer
+// codec：默认解码方式，消息的 ContentType 没有注册时使用
+// handler：处理函数
+func ConsumeTyped[T any](c IConsumer, codec Codec, handler TypedHandler[T]) error {
+	return c.ConsumeMessage(func(ctx context.Context, msg *Message) error {
+		cd, ok := CodecFor(msg.ContentType)
+		if !ok {
+			cd = codec
+		}
+		v, target := newTypedValue[T]()
+		if err := cd.Unmarshal(msg.Body, target); err != nil {
+			return Reject(fmt.Errorf("unmarshal %s: %w", cd.ContentType(), err))
+		}
+		return handler(ctx, *v)
+	})
+}
+
+// newTypedValue 返回 T 的零值以及用于解码的目标
+// T 为指针类型时（例如 protobuf 生成的 *pb.Xxx）分配好指向的对象，直接以它作为解码目标，否则以 &v 作为解码目标
+func newTypedValue[T any]() (*T, any) {
+	v := new(T)
+	if rt := reflect.TypeOf(*v); rt != nil && rt.Kind() == reflect.Pointer {
+		*v = reflect.New(rt.Elem()).Interface().(T)
+		return v, *v
+	}
+	return v, v
+}